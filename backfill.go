@@ -0,0 +1,157 @@
+package datastore
+
+// legacySlugs is the hash where the documents' slugs were kept, for
+// all the doctypes, before they were scoped by doctype.
+const legacySlugs = "documents"
+
+// slugsScript adds the slugs on ARGV, pairs of slug and document's
+// ID, to the doctypes of their documents: the document's current slug
+// to `<doctypeID>/slugs` and any other to it's history. Slugs already
+// taken are left as they are. Returns how many were added.
+const slugsScript = `
+local added = 0
+
+for i = 1, #ARGV, 2 do
+	local slug, doc = ARGV[i], ARGV[i + 1]
+
+	if redis.call('TYPE', doc).ok == 'hash' and redis.call('HGET', doc, 'type') == 'document' then
+		local doctype = redis.call('HGET', doc, 'doctype')
+		if doctype and redis.call('HGET', doc, 'slug') == slug then
+			if redis.call('HSETNX', doctype .. '/slugs', slug, doc) == 1 then
+				redis.call('HSETNX', doc .. '/unique', doctype .. '/slugs', slug)
+				added = added + 1
+			end
+		elseif doctype then
+			added = added + redis.call('HSETNX', doctype .. '/slug_history', slug, doc)
+		end
+	end
+end
+
+return added
+`
+
+// BackfillSlugs adds the slugs of the documents saved before slugs
+// were scoped by doctype to their doctypes, so LoadDocumentBySlug
+// finds them. It should be run once, after upgrading a database with
+// such documents, and is safe to run again.
+// Returns how many slugs were added.
+func BackfillSlugs() (int, error) {
+	added := 0
+
+	var cursor int64
+	for {
+		var pairs []string
+		var err error
+
+		cursor, pairs, err = Conn.HScan(legacySlugs, cursor, "", 1000).Result()
+		if err != nil {
+			return added, err
+		}
+
+		if len(pairs) > 0 {
+			n, err := Conn.Eval(slugsScript, []string{}, pairs).Result()
+			if err != nil {
+				return added, err
+			}
+			added += int(n.(int64))
+		}
+
+		if cursor == 0 {
+			return added, nil
+		}
+	}
+}
+
+// changesScript adds the revisions on ARGV not on the change log yet to
+// it, on their order. Returns how many were added.
+const changesScript = `
+local added = 0
+
+for i = 1, #ARGV do
+	if not redis.call('ZSCORE', KEYS[1], ARGV[i]) then
+		redis.call('ZADD', KEYS[1], redis.call('INCR', KEYS[2]), ARGV[i])
+		added = added + 1
+	end
+end
+
+return added
+`
+
+// BackfillChanges adds the revisions saved before the changes were
+// numbered to the change log, on the order they were made, so Changes
+// lists them. It should be run once, after upgrading a database with
+// such revisions and before it's written to again, or they're listed
+// after the changes made meanwhile. It's safe to run again.
+// Returns how many changes were added.
+func BackfillChanges() (int, error) {
+	added := 0
+
+	for start := int64(0); ; start += 1000 {
+		ids, err := Conn.ZRange("revisions", start, start+999).Result()
+		if err != nil {
+			return added, err
+		}
+
+		if len(ids) > 0 {
+			n, err := Conn.Eval(changesScript, []string{changesKey, changesSequence}, ids).Result()
+			if err != nil {
+				return added, err
+			}
+			added += int(n.(int64))
+		}
+
+		if len(ids) < 1000 {
+			return added, nil
+		}
+	}
+}
+
+// indexScript adds the documents among KEYS to the index of their
+// doctype. Returns how many were added.
+const indexScript = `
+local added = 0
+
+for i = 1, #KEYS do
+	if redis.call('TYPE', KEYS[i]).ok == 'hash' and redis.call('HGET', KEYS[i], 'type') == 'document' then
+		local doctype = redis.call('HGET', KEYS[i], 'doctype')
+		if doctype then
+			added = added + redis.call('SADD', doctype .. '/documents', KEYS[i])
+		end
+	end
+end
+
+return added
+`
+
+// BackfillDocumentIndexes adds the documents saved before the doctypes
+// indexed them to the `<doctypeID>/documents` sets, so DocumentIDs,
+// LoadDocumentsOf and Migrate find them. It walks the whole database,
+// so it should be run once, by a single process, after upgrading a
+// database with such documents. It's safe to run again.
+// Returns how many documents were added.
+func BackfillDocumentIndexes() (int, error) {
+	added := 0
+
+	var cursor int64
+	for {
+		var keys []string
+		var err error
+
+		cursor, keys, err = Conn.Scan(cursor, "", 1000).Result()
+		if err != nil {
+			return added, err
+		}
+
+		if len(keys) > 0 {
+			n, err := Conn.Eval(indexScript, keys, []string{}).Result()
+			if err != nil {
+				return added, err
+			}
+			added += int(n.(int64))
+		}
+
+		if cursor == 0 {
+			return added, nil
+		}
+	}
+}
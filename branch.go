@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"fmt"
+)
+
+// DefaultBranch is the branch stored on the document's base hash.
+// It's the one returned by LoadDocumentByID.
+const DefaultBranch = "master"
+
+// BranchHead returns the ID of the head revision of a document's branch.
+func BranchHead(id string, branch string) (string, error) {
+	var head string
+
+	if len(branch) == 0 || branch == DefaultBranch {
+		head = Conn.HGet(id, "revision").Val()
+	} else {
+		head = Conn.HGet(joinKey([]string{id, "branches"}), branch).Val()
+	}
+
+	if len(head) == 0 {
+		return head, fmt.Errorf("Could not find branch '%s' for document %s", branch, id)
+	}
+
+	return head, nil
+}
+
+// Branches returns all the branches of a document mapped to their
+// head revisions, including the DefaultBranch.
+func Branches(id string) (map[string]string, error) {
+	branches, err := Conn.HGetAllMap(joinKey([]string{id, "branches"})).Result()
+	if err != nil {
+		return branches, err
+	}
+
+	head, err := BranchHead(id, DefaultBranch)
+	if err != nil {
+		return branches, err
+	}
+	branches[DefaultBranch] = head
+
+	return branches, nil
+}
+
+// LoadDocumentBranch loads a document from the head of the given branch.
+func LoadDocumentBranch(id string, branch string) (*Document, error) {
+	if len(branch) == 0 || branch == DefaultBranch {
+		return LoadDocumentByID(id)
+	}
+
+	head, err := BranchHead(id, branch)
+	if err != nil {
+		return &Document{ID: id}, err
+	}
+
+	d, err := LoadDocumentRevision(id, head)
+	d.Branch = branch
+
+	return d, err
+}
+
+// PromoteBranch sets the head of the branch `to` to the head of the
+// branch `from`.
+//
+// Promoting to the DefaultBranch saves the branch's head as a new
+// revision of the document, validated against the current definition
+// of it's doctype, so it's what LoadDocumentByID will return and it's
+// recorded as any other change.
+func PromoteBranch(id string, from string, to string) error {
+	d, err := LoadDocumentBranch(id, from)
+	if err != nil {
+		return err
+	}
+
+	if len(to) > 0 && to != DefaultBranch {
+		d.Branch = to
+		return intercept(&Operation{Kind: OpSave, ObjectType: "document", ID: d.ID, Object: d}, func() error {
+			return Conn.HSet(joinKey([]string{id, "branches"}), to, d.Revision.ID).Err()
+		})
+	}
+
+	d.Doctype, err = LoadDoctypeByID(d.Doctype.ID)
+	if err != nil {
+		return err
+	}
+
+	d.Branch = DefaultBranch
+	d.promoted = from
+
+	return d.Save()
+}
@@ -0,0 +1,126 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestBranch(t *testing.T) {
+	Convey("Create a document on the default branch", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "article",
+			"verbose_name": "Article",
+			"fields": {
+				"title": {
+					"verbose_name": "Title",
+					"expected_types": ["string"]
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+
+		doctypeCreated.Save()
+
+		documentCreated := &Document{
+			Slug:        "branched-article",
+			DoctypeCode: "article",
+			Fields:      map[string]interface{}{"title": "Published title"},
+		}
+		documentCreated.Save()
+
+		Convey("Save a draft", func() {
+			draft, draftErr := LoadDocumentByID(documentCreated.ID)
+			if draftErr != nil {
+				panic(draftErr)
+			}
+
+			draft.Branch = "draft"
+			draft.Fields["title"] = "Draft title"
+			draft.Save()
+
+			Convey("Default branch stays untouched", func() {
+				documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+				if documentLoadedErr != nil {
+					panic(documentLoadedErr)
+				}
+
+				So(documentLoaded.Fields["title"], ShouldEqual, "Published title")
+				So(documentLoaded.Revision.ID, ShouldEqual, documentCreated.Revision.ID)
+			})
+
+			Convey("Load the draft from it's branch", func() {
+				draftLoaded, draftLoadedErr := LoadDocumentBranch(documentCreated.ID, "draft")
+				if draftLoadedErr != nil {
+					panic(draftLoadedErr)
+				}
+
+				So(draftLoaded.Fields["title"], ShouldEqual, "Draft title")
+				So(draftLoaded.Revision.ID, ShouldEqual, draft.Revision.ID)
+				So(draftLoaded.Revision.Parent, ShouldEqual, documentCreated.Revision.ID)
+			})
+
+			Convey("Promote the draft to the default branch", func() {
+				cursor, cursorErr := LatestCursor()
+				if cursorErr != nil {
+					panic(cursorErr)
+				}
+
+				promoteErr := PromoteBranch(documentCreated.ID, "draft", DefaultBranch)
+				if promoteErr != nil {
+					panic(promoteErr)
+				}
+
+				documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+				if documentLoadedErr != nil {
+					panic(documentLoadedErr)
+				}
+
+				So(documentLoaded.Fields["title"], ShouldEqual, "Draft title")
+				So(documentLoaded.Revision.Parent, ShouldEqual, draft.Revision.ID)
+				So(documentLoaded.Revision.Message, ShouldEqual, "Promote branch 'draft'")
+				So(documentLoaded.DoctypeRevision, ShouldEqual, doctypeCreated.Revision.ID)
+
+				// it's a change like any other
+				changes, _, changesErr := Changes(cursor, 10)
+				if changesErr != nil {
+					panic(changesErr)
+				}
+				So(changes, ShouldHaveLength, 1)
+				So(changes[0].Revision, ShouldEqual, documentLoaded.Revision.ID)
+
+				// the draft is part of the default branch's history
+				ancestor, ancestorErr := CommonAncestor(documentLoaded.Revision.ID, draft.Revision.ID)
+				if ancestorErr != nil {
+					panic(ancestorErr)
+				}
+				So(ancestor, ShouldEqual, draft.Revision.ID)
+			})
+
+			Convey("Drafts promoted are validated", func() {
+				draft.Fields["title"] = 42
+				So(draft.Save(), ShouldNotBeNil)
+
+				draft.Fields["title"] = "Valid title"
+				So(draft.Save(), ShouldBeNil)
+
+				// the doctype stopped accepting strings meanwhile
+				doctypeCreated.Fields["title"].ExpectedTypes = []string{"int"}
+				So(doctypeCreated.Save(), ShouldBeNil)
+
+				So(PromoteBranch(documentCreated.ID, "draft", DefaultBranch), ShouldNotBeNil)
+
+				documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+				if documentLoadedErr != nil {
+					panic(documentLoadedErr)
+				}
+				So(documentLoaded.Revision.ID, ShouldEqual, documentCreated.Revision.ID)
+			})
+		})
+	})
+}
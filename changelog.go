@@ -0,0 +1,166 @@
+package datastore
+
+import (
+	"fmt"
+	"gopkg.in/redis.v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change is an entry of the change log.
+type Change struct {
+	// Position of the change on the log, pass it to Changes to
+	// continue from here.
+	Cursor string `json:"cursor"`
+
+	// Object changed and it's type, like doctype or document
+	ObjectID   string `json:"object_id"`
+	ObjectType string `json:"object_type"`
+
+	// Kind of change, like create or update
+	ChangeType string `json:"change_type"`
+
+	// Revision made by the change
+	Revision string    `json:"revision"`
+	When     time.Time `json:"when"`
+}
+
+// changesKey is the sorted set of the change log, the revisions of the
+// doctypes and documents scored by the order they were committed in.
+const changesKey = "changes"
+
+// changesSequence numbers the changes logged.
+const changesSequence = "changes/sequence"
+
+// logScript adds the revision to the change log with the next number
+// of the sequence. It runs when the change is committed, so a change
+// logged after a cursor was given always comes after it, whatever the
+// time of it's revision.
+const logScript = `
+local n = redis.call('INCR', KEYS[2])
+redis.call('ZADD', KEYS[1], n, ARGV[1])
+return n
+`
+
+// logChange adds the revision to the change log on the same pipeline
+// the change is committed with.
+func logChange(revisionID string, pipeline *redis.Pipeline) {
+	pipeline.Eval(logScript, []string{changesKey, changesSequence}, []string{revisionID})
+}
+
+// Changes lists up to limit changes made to doctypes and documents
+// after the cursor, on the order they were committed.
+//
+// An empty cursor starts from the beginning of the log. The cursor of
+// the last change returned is also returned, so it can be used on the
+// next call. It's the same cursor given when there are no new changes.
+func Changes(cursor string, limit int) ([]Change, string, error) {
+	var changes []Change
+
+	op := &Operation{Kind: OpQuery, ObjectType: "change"}
+	err := intercept(op, func() (err error) {
+		changes, cursor, err = changesAfter(cursor, limit)
+		op.Object = changes
+		return err
+	})
+
+	if result, ok := op.Object.([]Change); ok {
+		changes = result
+	}
+
+	return changes, cursor, err
+}
+
+func changesAfter(cursor string, limit int) ([]Change, string, error) {
+	changes := []Change{}
+	min := "-inf"
+
+	if len(cursor) > 0 {
+		after, err := parseCursor(cursor)
+		if err != nil {
+			return changes, cursor, err
+		}
+		min = "(" + strconv.FormatInt(after, 10)
+	}
+
+	members, err := Conn.ZRangeByScoreWithScores(changesKey, redis.ZRangeByScore{
+		Min:   min,
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return changes, cursor, err
+	}
+
+	for _, z := range members {
+		change, err := loadChange(fmt.Sprint(z.Member), int64(z.Score))
+		if err != nil {
+			return changes, cursor, err
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) > 0 {
+		cursor = changes[len(changes)-1].Cursor
+	}
+
+	return changes, cursor, nil
+}
+
+// LatestCursor returns the cursor of the last change made, so changes
+// can be followed from now on.
+func LatestCursor() (string, error) {
+	members, err := Conn.ZRangeWithScores(changesKey, -1, -1).Result()
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+
+	return formatCursor(int64(members[0].Score)), nil
+}
+
+// loadChange builds a change from the revision it made.
+func loadChange(revisionID string, n int64) (Change, error) {
+	r, err := LoadRevisionByID(revisionID)
+	if err != nil {
+		return Change{}, err
+	}
+
+	// revisions saved before object types were recorded
+	if len(r.ObjectType) == 0 {
+		r.ObjectType = Conn.HGet(r.Object, "type").Val()
+	}
+
+	return Change{
+		Cursor:     formatCursor(n),
+		ObjectID:   r.Object,
+		ObjectType: r.ObjectType,
+		ChangeType: r.Type,
+		Revision:   r.ID,
+		When:       r.When,
+	}, nil
+}
+
+func formatCursor(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// parseCursor returns the number of the change the cursor is on.
+// Cursors given before changes were numbered, the revision's score
+// and ID, are on the number of their revision.
+func parseCursor(cursor string) (int64, error) {
+	if parts := strings.SplitN(cursor, ":", 2); len(parts) == 2 {
+		n, err := Conn.ZScore(changesKey, parts[1]).Result()
+		if err != nil {
+			return 0, fmt.Errorf("Invalid cursor '%s'", cursor)
+		}
+		return int64(n), nil
+	}
+
+	n, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid cursor '%s'", cursor)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestChangelog(t *testing.T) {
+	Convey("Follow changes from the latest cursor", t, func() {
+		cursor, cursorErr := LatestCursor()
+		if cursorErr != nil {
+			panic(cursorErr)
+		}
+
+		doctypeCreated := &Doctype{
+			Code:        "event",
+			VerboseName: "Event",
+			Fields: map[string]*Field{
+				"name": {VerboseName: "Name", ExpectedTypes: []string{"string"}},
+			},
+		}
+		doctypeCreated.Save()
+
+		documentCreated := &Document{
+			Slug:        "followed-event",
+			DoctypeCode: "event",
+			Fields:      map[string]interface{}{"name": "Created"},
+		}
+		documentCreated.Save()
+
+		documentCreated.Fields["name"] = "Updated"
+		documentCreated.Save()
+
+		Convey("List the changes", func() {
+			changes, next, changesErr := Changes(cursor, 10)
+			if changesErr != nil {
+				panic(changesErr)
+			}
+
+			So(changes, ShouldHaveLength, 3)
+			So(changes[0].ObjectID, ShouldEqual, doctypeCreated.ID)
+			So(changes[0].ObjectType, ShouldEqual, "doctype")
+			So(changes[1].ObjectType, ShouldEqual, "document")
+			So(changes[1].ChangeType, ShouldEqual, "create")
+			So(changes[2].ChangeType, ShouldEqual, "update")
+			So(changes[2].Revision, ShouldEqual, documentCreated.Revision.ID)
+			So(next, ShouldEqual, changes[2].Cursor)
+
+			Convey("Nothing new after the last cursor", func() {
+				changes, after, changesErr := Changes(next, 10)
+				if changesErr != nil {
+					panic(changesErr)
+				}
+
+				So(changes, ShouldBeEmpty)
+				So(after, ShouldEqual, next)
+			})
+		})
+
+		Convey("Changes committed after the cursor are listed", func() {
+			_, next, changesErr := Changes(cursor, 10)
+			if changesErr != nil {
+				panic(changesErr)
+			}
+
+			// a save whose revision was made before the last change
+			// listed, but committed after it
+			late := UpdateRevision(documentCreated.Revision)
+			late.When = documentCreated.Revision.When.Add(-time.Second)
+
+			pipeline := Conn.Pipeline()
+			late.Save(pipeline)
+			logChange(late.ID, pipeline)
+			_, execErr := pipeline.Exec()
+			pipeline.Close()
+			if execErr != nil {
+				panic(execErr)
+			}
+
+			changes, _, changesErr := Changes(next, 10)
+			if changesErr != nil {
+				panic(changesErr)
+			}
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].Revision, ShouldEqual, late.ID)
+		})
+
+		Convey("Revisions saved before the changes were numbered are backfilled", func() {
+			_, next, changesErr := Changes(cursor, 10)
+			if changesErr != nil {
+				panic(changesErr)
+			}
+
+			Conn.ZRem(changesKey, documentCreated.Revision.ID)
+
+			added, backfillErr := BackfillChanges()
+			if backfillErr != nil {
+				panic(backfillErr)
+			}
+			So(added, ShouldBeGreaterThanOrEqualTo, 1)
+
+			// it's listed after the changes listed already
+			after, parseErr := parseCursor(next)
+			if parseErr != nil {
+				panic(parseErr)
+			}
+			n, scoreErr := Conn.ZScore(changesKey, documentCreated.Revision.ID).Result()
+			if scoreErr != nil {
+				panic(scoreErr)
+			}
+			So(int64(n), ShouldBeGreaterThan, after)
+		})
+	})
+}
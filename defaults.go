@@ -0,0 +1,111 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultGenerator generates a field's default value.
+type DefaultGenerator func(d *Document, f *Field) (interface{}, error)
+
+// DefaultGenerators available to the fields, by name.
+var DefaultGenerators = map[string]DefaultGenerator{
+	// current time, formatted as RFC 3339
+	"now": func(d *Document, f *Field) (interface{}, error) {
+		return time.Now().UTC().Format(time.RFC3339Nano), nil
+	},
+
+	// random UUID
+	"uuid": func(d *Document, f *Field) (interface{}, error) {
+		return GenerateUUID(), nil
+	},
+
+	// sequence, starting on 1, shared by all documents of the doctype
+	"autoincrement": func(d *Document, f *Field) (interface{}, error) {
+		key := joinKey([]string{d.Doctype.ID, "autoincrement"})
+
+		n, err := Conn.HIncrBy(key, f.ID, 1).Result()
+		return float64(n), err
+	},
+}
+
+// ComputeFunc computes a field's value from the document.
+type ComputeFunc func(d *Document) (interface{}, error)
+
+// computeFuncs registered by name.
+var (
+	computeFuncs      = map[string]ComputeFunc{}
+	computeFuncsMutex sync.RWMutex
+)
+
+// RegisterComputed registers a function computing fields' values,
+// so it can be set as the Computed of fields. It's safe to call while
+// documents are saved.
+func RegisterComputed(name string, fn ComputeFunc) {
+	computeFuncsMutex.Lock()
+	defer computeFuncsMutex.Unlock()
+
+	computeFuncs[name] = fn
+}
+
+// computeFunc returns the function registered by name.
+func computeFunc(name string) (ComputeFunc, bool) {
+	computeFuncsMutex.RLock()
+	defer computeFuncsMutex.RUnlock()
+
+	fn, ok := computeFuncs[name]
+	return fn, ok
+}
+
+// applyDefaults sets the default values of the fields without one.
+func (d *Document) applyDefaults() error {
+	if d.Fields == nil {
+		d.Fields = make(map[string]interface{})
+	}
+
+	for code, field := range d.Doctype.Fields {
+		if field.Deprecated || !isEmpty(d.Fields[code]) {
+			continue
+		}
+
+		if len(field.DefaultGenerator) > 0 {
+			generate, ok := DefaultGenerators[field.DefaultGenerator]
+			if !ok {
+				return fmt.Errorf("Unknown default generator '%s' for field %s", field.DefaultGenerator, code)
+			}
+
+			value, err := generate(d, field)
+			if err != nil {
+				return err
+			}
+			d.Fields[code] = value
+		} else if field.Default != nil {
+			d.Fields[code] = field.Default
+		}
+	}
+
+	return nil
+}
+
+// applyComputed sets the values of the computed fields.
+func (d *Document) applyComputed() error {
+	for code, field := range d.Doctype.Fields {
+		if field.Deprecated || len(field.Computed) == 0 {
+			continue
+		}
+
+		compute, ok := computeFunc(field.Computed)
+		if !ok {
+			return fmt.Errorf("Unknown computed function '%s' for field %s", field.Computed, code)
+		}
+
+		value, err := compute(d)
+		if err != nil {
+			return err
+		}
+		d.Fields[code] = value
+	}
+
+	return nil
+}
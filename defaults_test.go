@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	RegisterComputed("full_name", func(d *Document) (interface{}, error) {
+		return fmt.Sprint(d.Fields["first_name"], " ", d.Fields["last_name"]), nil
+	})
+
+	Convey("Create a doctype with default and computed fields", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "person",
+			"verbose_name": "Person",
+			"fields": {
+				"first_name": {
+					"verbose_name": "First name",
+					"expected_types": ["string"]
+				},
+				"last_name": {
+					"verbose_name": "Last name",
+					"expected_types": ["string"],
+					"default": "Doe"
+				},
+				"number": {
+					"verbose_name": "Number",
+					"expected_types": ["int"],
+					"default_generator": "autoincrement"
+				},
+				"full_name": {
+					"verbose_name": "Full name",
+					"expected_types": ["string"],
+					"computed": "full_name"
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		first := &Document{
+			Slug:        "john",
+			DoctypeCode: "person",
+			Fields:      map[string]interface{}{"first_name": "John"},
+		}
+		So(first.Save(), ShouldBeNil)
+
+		Convey("Defaults are set on creation", func() {
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+
+			So(documentLoaded.Fields["last_name"], ShouldEqual, "Doe")
+			So(documentLoaded.Fields["number"], ShouldEqual, 1.0)
+			So(documentLoaded.Fields["full_name"], ShouldEqual, "John Doe")
+
+			second := &Document{
+				Slug:        "jane",
+				DoctypeCode: "person",
+				Fields:      map[string]interface{}{"first_name": "Jane", "last_name": "Roe"},
+			}
+			So(second.Save(), ShouldBeNil)
+			So(second.Fields["number"], ShouldEqual, 2.0)
+			So(second.Fields["full_name"], ShouldEqual, "Jane Roe")
+		})
+
+		Convey("Computed fields are updated on every save", func() {
+			first.Fields["last_name"] = "Smith"
+			So(first.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+
+			So(documentLoaded.Fields["full_name"], ShouldEqual, "John Smith")
+			So(documentLoaded.Fields["number"], ShouldEqual, 1.0)
+		})
+
+		Convey("Functions are registered while documents are saved", func() {
+			wg := sync.WaitGroup{}
+			errs := make(chan error, 10)
+
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					RegisterComputed(fmt.Sprint("initials_", i), func(d *Document) (interface{}, error) {
+						return "", nil
+					})
+				}(i)
+				go func() {
+					defer wg.Done()
+					errs <- (&Document{DoctypeCode: "person", Fields: map[string]interface{}{"first_name": "Jim"}}).Save()
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"reflect"
+)
+
+// FieldDiff holds the values of a field changed between two revisions.
+type FieldDiff struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Diff returns the fields with different values on both documents.
+// Any of them can be nil, as if the document had no values.
+func Diff(from *Document, to *Document) map[string]FieldDiff {
+	diff := map[string]FieldDiff{}
+
+	fields := map[string]*Field{}
+	for _, d := range []*Document{from, to} {
+		if d != nil && d.Doctype != nil {
+			for code, field := range d.Doctype.Fields {
+				fields[code] = field
+			}
+		}
+	}
+
+	for code, field := range fields {
+		var before, after interface{}
+
+		if from != nil {
+			before = from.Fields[code]
+		}
+		if to != nil {
+			after = to.Fields[code]
+		}
+
+		if sameValue(field, before, after) {
+			continue
+		}
+
+		diff[code] = FieldDiff{Old: before, New: after}
+	}
+
+	return diff
+}
+
+// DiffRevision returns what changed on a document's revision
+// compared to it's parent.
+func DiffRevision(id string, revisionID string) (map[string]FieldDiff, error) {
+	to, err := LoadDocumentRevision(id, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(to.Revision.Parent) == 0 {
+		return Diff(nil, to), nil
+	}
+
+	from, err := LoadDocumentRevision(id, to.Revision.Parent)
+	if err != nil {
+		return nil, err
+	}
+
+	// deleted documents have no values
+	if to.Revision.Type == "delete" {
+		return Diff(from, nil), nil
+	}
+
+	return Diff(from, to), nil
+}
+
+// sameValue compares two values of the field.
+// Multiple values are compared as sets.
+func sameValue(f *Field, a interface{}, b interface{}) bool {
+	if f.inSet() {
+		as, bs := toStringSlice(a), toStringSlice(b)
+		if len(as) != len(bs) {
+			return false
+		}
+
+		set := stringSet(as)
+		for _, value := range bs {
+			if !set[value] {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"gopkg.in/redis.v3"
 	"io"
+	"strconv"
 )
 
 // Doctype representation
@@ -26,8 +27,30 @@ type Doctype struct {
 	// Fields' definitions.
 	Fields map[string]*Field `json:"fields"`
 
+	// Strict doctypes refuse documents with values for fields they
+	// don't define. Otherwise those values are kept as they are.
+	Strict bool `json:"strict"`
+
+	// Groups of fields' codes whose values, together, can't be
+	// repeated by two documents.
+	UniqueTogether [][]string `json:"unique_together,omitempty"`
+
+	// Code of the field the slug of documents created without one
+	// is generated from.
+	SlugField string `json:"slug_field,omitempty"`
+
+	// Codes of the doctypes this one extends, inheriting their fields.
+	Extends []string `json:"extends,omitempty"`
+
 	// Last revision of the doctype.
 	Revision *Revision `json:"revision"`
+
+	// Message of the next revision saved.
+	message string
+
+	// Revisions of the doctypes the fields were inherited from, by
+	// code, so older revisions load with the fields of their time.
+	inherited map[string]string
 }
 
 // Decode implements json.Decoder
@@ -36,23 +59,48 @@ func (d *Doctype) Decode(r io.Reader) error {
 }
 
 // Save the doctype definition to the database.
-func (d *Doctype) Save() {
+func (d *Doctype) Save() error {
+	return intercept(&Operation{Kind: OpSave, ObjectType: "doctype", ID: d.ID, Object: d}, d.save)
+}
+
+func (d *Doctype) save() error {
 	var err error
 	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
 
 	// Generates an ID if there's no one set
 	if len(d.ID) == 0 {
 		d.ID = GenerateID(4)
 	}
 
-	// create, set and Save a new Revision.
-	d.Revision = CreateRevision(d.ID)
+	// refresh the inherited fields, and check the parents exist
+	err = d.inherit()
+	if err != nil {
+		return err
+	}
+
+	err = d.generateFieldIDs()
+	if err != nil {
+		return err
+	}
+
+	// create, set and Save a new Revision. Doctypes already saved
+	// get a revision descending from the last one.
+	if d.Revision != nil && len(d.Revision.ID) > 0 {
+		d.Revision = UpdateRevision(d.Revision)
+		d.Revision.Object = d.ID
+	} else {
+		d.Revision = CreateRevision(d.ID)
+	}
+	d.Revision.ObjectType = "doctype"
+	d.Revision.Message = d.message
+	d.message = ""
 	d.Revision.Save(pipeline)
 
 	// add this revision to a sorted set so we can retrieve all
 	// the revisions on a chronological order.
 	pipeline.ZAdd(joinKey([]string{d.ID, "revisions"}), redis.Z{
-		Score:  float64(d.Revision.When.Unix()),
+		Score:  d.Revision.Score(),
 		Member: d.Revision.ID,
 	})
 
@@ -66,16 +114,55 @@ func (d *Doctype) Save() {
 
 	pipeline.HSet(d.ID, "type", "doctype")
 
+	uniqueTogether, err := json.Marshal(d.UniqueTogether)
+	if err != nil {
+		return err
+	}
+
+	extends, err := json.Marshal(d.Extends)
+	if err != nil {
+		return err
+	}
+
+	inherited, err := json.Marshal(d.inherited)
+	if err != nil {
+		return err
+	}
+
 	// Inside this loop there's everything that should be
 	// written to the history of changes (or Revision).
 	// That's why I loop over the Doctype.ID and Revision.ID
 	for _, baseID := range []string{d.ID, d.Revision.ID} {
 		pipeline.HSet(baseID, "code", d.Code)
 		pipeline.HSet(baseID, "verbose_name", d.VerboseName)
+		pipeline.HSet(baseID, "strict", strconv.FormatBool(d.Strict))
+		pipeline.HSet(baseID, "unique_together", string(uniqueTogether))
+		pipeline.HSet(baseID, "slug_field", d.SlugField)
+		pipeline.HSet(baseID, "extends", string(extends))
+		pipeline.HSet(baseID, "inherited", string(inherited))
+	}
+
+	// fields removed from the definition leave the doctype's fields set,
+	// they're kept on the database only for the older revisions.
+	current := make(map[string]bool)
+	for _, field := range d.Fields {
+		if len(field.InheritedFrom) == 0 {
+			current[field.ID] = true
+		}
+	}
+	for _, fieldID := range Conn.SMembers(joinKey([]string{d.ID, "fields"})).Val() {
+		if !current[fieldID] {
+			pipeline.SRem(joinKey([]string{d.ID, "fields"}), fieldID)
+		}
 	}
 
 	// Loop over fields to save them the the database.
+	// The inherited ones are saved by their own doctypes.
 	for fieldCode, field := range d.Fields {
+		if len(field.InheritedFrom) > 0 {
+			continue
+		}
+
 		// Fillup missing data
 		field.Code = fieldCode
 		field.Revision = d.Revision
@@ -83,51 +170,203 @@ func (d *Doctype) Save() {
 		field.Save(d, pipeline)
 	}
 
+	logChange(d.Revision.ID, pipeline)
+	appendChange(d.event(), pipeline)
+
 	_, err = pipeline.Exec()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	pipeline.Close()
+	Doctypes.Invalidate()
+	publish(d.event())
+
+	return nil
 }
 
-// LoadDoctypeByID loads a doctype's definition from the database by ID
+// event describing the last change made to the doctype.
+func (d *Doctype) event() Event {
+	return Event{
+		ObjectID:    d.ID,
+		ObjectType:  "doctype",
+		DoctypeCode: d.Code,
+		ChangeType:  d.Revision.Type,
+		Revision:    d.Revision.ID,
+		When:        d.Revision.When,
+	}
+}
+
+// LoadDoctypeByID loads a doctype's definition by ID, from the Doctypes
+// registry when it's cached there or else from the database.
+//
+// Loading it from the database takes the same round-trips whatever it's
+// number of fields, plus up to four for each doctype it inherits from,
+// directly or not, as they're read one after the other.
 func LoadDoctypeByID(id string) (*Doctype, error) {
+	op := &Operation{Kind: OpLoad, ObjectType: "doctype", ID: id}
+
+	err := intercept(op, func() error {
+		d, err := Doctypes.load(id)
+		op.Object = d
+		return err
+	})
+
+	d, ok := op.Object.(*Doctype)
+	if !ok {
+		d = &Doctype{ID: id}
+	}
+
+	return d, err
+}
+
+func loadDoctypeByID(id string) (*Doctype, error) {
 	var err error
 
 	d := &Doctype{}
 	d.ID = id
 
 	// get all basic information from base hash
-	get := Conn.HGetAllMap(id).Val()
+	get, fieldIDs, err := readDefinition(id)
+	if err != nil {
+		return d, err
+	}
+
+	if len(get) == 0 {
+		return d, &NotFoundError{ObjectType: "doctype", By: "ID", Keys: []string{id}}
+	}
 
 	if get["type"] != "doctype" {
 		return d, fmt.Errorf("%s is type '%s', expecting 'doctype'", id, get["type"])
 	}
 
+	err = d.decode(id, get, fieldIDs)
+	if err != nil {
+		return d, err
+	}
+
+	return d, d.inherit()
+}
+
+// LoadDoctypeRevision loads a doctype's definition as it was on the
+// given revision.
+func LoadDoctypeRevision(id string, revisionID string) (*Doctype, error) {
+	op := &Operation{Kind: OpLoad, ObjectType: "doctype", ID: id}
+
+	err := intercept(op, func() error {
+		d, err := loadDoctypeRevision(id, revisionID)
+		op.Object = d
+		return err
+	})
+
+	d, ok := op.Object.(*Doctype)
+	if !ok {
+		d = &Doctype{ID: id}
+	}
+
+	return d, err
+}
+
+func loadDoctypeRevision(id string, revisionID string) (*Doctype, error) {
+	var err error
+
+	d := &Doctype{}
+	d.ID = id
+
+	// the revision's hash holds a copy of the doctype's definition
+	get, fieldIDs, err := readDefinition(revisionID)
+	if err != nil {
+		return d, err
+	}
+
+	if len(get) == 0 {
+		return d, &NotFoundError{ObjectType: "revision", By: "ID", Keys: []string{revisionID}}
+	}
+
+	if get["object"] != id {
+		return d, fmt.Errorf("Revision %s doesn't belong to doctype %s", revisionID, id)
+	}
+
+	d.Revision = &Revision{}
+	err = d.Revision.decode(revisionID, get)
+	if err != nil {
+		return d, err
+	}
+
+	err = d.decode(revisionID, get, fieldIDs)
+	if err != nil {
+		return d, err
+	}
+
+	// revisions saved before the parents' ones were recorded
+	// inherit the parents as they're now.
+	return d, d.inheritRevisions(d.inherited)
+}
+
+// readDefinition reads the hash of a doctype's definition from the
+// given base key, the doctype's ID or one of it's revisions, along with
+// it's fields' IDs, on a single round-trip.
+func readDefinition(baseID string) (map[string]string, []string, error) {
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	get := pipeline.HGetAllMap(baseID)
+	fieldIDs := pipeline.SMembers(joinKey([]string{baseID, "fields"}))
+
+	_, err := pipeline.Exec()
+
+	return get.Val(), fieldIDs.Val(), err
+}
+
+// decode the doctype's definition from the given base key, it's hash
+// and it's fields' IDs. The doctype's last revision, when not set yet,
+// is read along with the fields' ones.
+// Whatever the number of fields, it's at most two round-trips.
+func (d *Doctype) decode(baseID string, get map[string]string, fieldIDs []string) error {
 	d.Code = get["code"]
 	d.VerboseName = get["verbose_name"]
+	d.Strict = get["strict"] == "true"
+	d.SlugField = get["slug_field"]
+
+	for name, value := range map[string]interface{}{
+		"unique_together": &d.UniqueTogether,
+		"extends":         &d.Extends,
+		"inherited":       &d.inherited,
+	} {
+		if len(get[name]) > 0 {
+			err := json.Unmarshal([]byte(get[name]), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	d.Fields = make(map[string]*Field)
 
-	// load fields ids so we can load the fields
-	fieldIds := Conn.SMembers(joinKey([]string{id, "fields"})).Val()
-	for _, fieldID := range fieldIds {
-		LoadFieldByID(d, fieldID)
+	revisions := make(map[string]*Revision)
+	if d.Revision == nil && len(get["revision"]) > 0 {
+		revisions[get["revision"]] = nil
 	}
 
-	d.Revision, err = LoadRevisionByID(get["revision"])
+	err := d.loadFields(baseID, fieldIDs, revisions)
 	if err != nil {
-		return d, err
+		return err
 	}
 
-	return d, nil
+	if d.Revision == nil && len(get["revision"]) > 0 {
+		d.Revision = revisions[get["revision"]]
+	}
+
+	return nil
 }
 
-// LoadDoctypeByCode loads a doctype's definition from the database by code
+// LoadDoctypeByCode loads a doctype's definition by code, like
+// LoadDoctypeByID.
 func LoadDoctypeByCode(code string) (*Doctype, error) {
-	doctypeID := Conn.HGet("doctypes", code).Val()
+	doctypeID, ok := Doctypes.idOf(code)
+	if !ok {
+		doctypeID = Conn.HGet("doctypes", code).Val()
+	}
 	if len(doctypeID) == 0 {
-		return &Doctype{}, fmt.Errorf("Could not find Doctype by code '%s'", code)
+		return &Doctype{}, &NotFoundError{ObjectType: "doctype", By: "code", Keys: []string{code}}
 	}
 	return LoadDoctypeByID(doctypeID)
 }
@@ -2,8 +2,12 @@ package datastore
 
 import (
 	"encoding/json"
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/redis.v3"
+	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -59,3 +63,149 @@ func TestDoctype(t *testing.T) {
 		})
 	})
 }
+
+// benchmarkDoctype saves a doctype with the given number of fields, every
+// other one with multiple values, and a document with values for all.
+func benchmarkDoctype(b testing.TB, fields int) (*Doctype, *Document) {
+	d := &Doctype{
+		Code:        "bench_" + GenerateID(4),
+		VerboseName: "Bench",
+		Fields:      make(map[string]*Field),
+	}
+	values := make(map[string]interface{})
+
+	for i := 0; i < fields; i++ {
+		code := fmt.Sprintf("field_%d", i)
+		d.Fields[code] = &Field{VerboseName: code, ExpectedTypes: []string{"string"}, MultipleValues: i%2 == 1}
+
+		if i%2 == 1 {
+			values[code] = []string{"a", "b"}
+		} else {
+			values[code] = "value"
+		}
+	}
+
+	err := d.Save()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	document := &Document{DoctypeCode: d.Code, Slug: GenerateID(8), Fields: values}
+	err = document.Save()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return d, document
+}
+
+// Loading a doctype takes the same round-trips whatever it's number of
+// fields, so the time per load should barely grow with them.
+func BenchmarkLoadDoctypeByID(b *testing.B) {
+	for _, fields := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("fields=%d", fields), func(b *testing.B) {
+			d, _ := benchmarkDoctype(b, fields)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// skip the registry, measuring the loads from the database
+				_, err := loadDoctypeByID(d.ID)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// countingConn counts the writes made to the connection. Commands and
+// pipelines are written at once, so it's the number of round-trips.
+type countingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// countRoundTrips counts the round-trips to the database made by fn.
+func countRoundTrips(fn func()) int {
+	var writes int64
+
+	conn := Conn
+	Conn = redis.NewClient(&redis.Options{
+		Network:  "tcp",
+		Addr:     "127.0.0.1:6379",
+		PoolSize: 1,
+		Dialer: func() (net.Conn, error) {
+			c, err := net.Dial("tcp", "127.0.0.1:6379")
+			if err != nil {
+				return nil, err
+			}
+			return &countingConn{c, &writes}, nil
+		},
+	})
+	defer func() {
+		Conn.Close()
+		Conn = conn
+	}()
+
+	fn()
+
+	return int(atomic.LoadInt64(&writes))
+}
+
+func TestLoadRoundTrips(t *testing.T) {
+	Convey("Create doctypes with few and many fields", t, func() {
+		few, fewDocument := benchmarkDoctype(t, 2)
+		many, manyDocument := benchmarkDoctype(t, 50)
+
+		loadDoctype := func(id string) func() {
+			return func() {
+				// skip the registry, counting the loads from the database
+				_, err := loadDoctypeByID(id)
+				if err != nil {
+					panic(err)
+				}
+			}
+		}
+
+		Convey("Doctypes load on the same round-trips", func() {
+			So(countRoundTrips(loadDoctype(few.ID)), ShouldEqual, countRoundTrips(loadDoctype(many.ID)))
+		})
+
+		Convey("Documents load on the same round-trips", func() {
+			loadDocument := func(id string) func() {
+				return func() {
+					_, err := loadDocuments([]string{id})
+					if err != nil {
+						panic(err)
+					}
+				}
+			}
+
+			// with their doctypes cached
+			waitForEvents()
+			loadDocument(fewDocument.ID)()
+			loadDocument(manyDocument.ID)()
+
+			So(countRoundTrips(loadDocument(fewDocument.ID)), ShouldEqual, countRoundTrips(loadDocument(manyDocument.ID)))
+		})
+
+		Convey("Each ancestor adds the same round-trips", func() {
+			child := &Doctype{Code: "child_" + GenerateID(4), Extends: []string{few.Code}}
+			So(child.Save(), ShouldBeNil)
+			grandchild := &Doctype{Code: "grandchild_" + GenerateID(4), Extends: []string{child.Code}}
+			So(grandchild.Save(), ShouldBeNil)
+
+			parentTrips := countRoundTrips(loadDoctype(few.ID))
+			childTrips := countRoundTrips(loadDoctype(child.ID))
+			grandchildTrips := countRoundTrips(loadDoctype(grandchild.ID))
+
+			So(childTrips-parentTrips, ShouldBeLessThanOrEqualTo, 4)
+			So(grandchildTrips-childTrips, ShouldEqual, childTrips-parentTrips)
+		})
+	})
+}
@@ -2,11 +2,19 @@ package datastore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gopkg.in/redis.v3"
 	"io"
+	"reflect"
+	"sort"
+	"strings"
 )
 
+// errDocumentChanged is returned by saves expecting the document on
+// a revision it isn't on anymore.
+var errDocumentChanged = errors.New("Document changed since it was loaded")
+
 type Documenter interface {
 	Slug() string
 	DoctypeCode() string
@@ -28,10 +36,35 @@ type Document struct {
 	DoctypeCode string   `json:"doctype"`
 	Doctype     *Doctype `json:"-"`
 
+	// Revision of the doctype the document was validated against
+	// when saved.
+	DoctypeRevision string `json:"doctype_revision,omitempty"`
+
 	Fields map[string]interface{} `json:"fields"`
 
 	// Last revision of the document.
 	Revision *Revision `json:"revision"`
+
+	// Branch the document was loaded from and will be saved to.
+	// Empty means the DefaultBranch.
+	Branch string `json:"branch,omitempty"`
+
+	// Revision being merged into this document, used as the
+	// second parent of the next revision.
+	mergeParent *Revision
+
+	// Branch being promoted to the DefaultBranch, recorded by the
+	// next revision.
+	promoted string
+
+	// Name of the last migration run on the document, and the ones
+	// run since it was loaded, recorded by the next revision.
+	migration string
+	migrated  []string
+
+	// Revision the document must still be on when saved, so changes
+	// saved meanwhile aren't overwritten. Empty saves over anything.
+	expectedHead string
 }
 
 // Decode implements json.Decoder
@@ -40,110 +73,488 @@ func (d *Document) Decode(r io.Reader) error {
 }
 
 // Save this document on the database.
-func (d *Document) Save() {
-	var err error
+func (d *Document) Save() error {
+	return intercept(&Operation{Kind: OpSave, ObjectType: "document", ID: d.ID, Object: d}, d.save)
+}
+
+func (d *Document) save() (err error) {
 	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
 
 	// Generates an ID if there's no one set
 	if len(d.ID) == 0 {
 		d.ID = GenerateID(8)
 	}
 
+	// saves of the same document wait for each other, so the
+	// head checked is the one the save replaces.
+	if d.Revision != nil {
+		var unlock func()
+		unlock, err = lockDocument(d.ID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if len(d.expectedHead) > 0 {
+			head, headErr := Conn.HGet(d.ID, "revision").Result()
+			if headErr != nil && headErr != redis.Nil {
+				return headErr
+			}
+			if head != d.expectedHead {
+				return errDocumentChanged
+			}
+		}
+	}
+
 	// load doctype so we can build and validate the document
 	if d.Doctype == nil {
 		d.Doctype, err = LoadDoctypeByCode(d.DoctypeCode)
 		if err != nil {
-			panic(err)
+			return err
+		}
+	}
+
+	// defaults are only set when the document is created,
+	// and it's created already migrated.
+	if d.Revision == nil {
+		err = d.applyDefaults()
+		if err != nil {
+			return err
+		}
+
+		d.migration = latestMigration(d.Doctype.Code)
+	}
+
+	err = d.applyComputed()
+	if err != nil {
+		return err
+	}
+
+	if len(d.Slug) == 0 && len(d.Doctype.SlugField) > 0 {
+		d.Slug = d.generateSlug()
+	}
+
+	err = d.Validate()
+	if err != nil {
+		return err
+	}
+	d.DoctypeRevision = d.Doctype.Revision.ID
+
+	// drafts on branches don't claim unique values
+	if !d.onBranch() {
+		oldSlug := d.claimedSlug()
+
+		var unclaim func() error
+		unclaim, err = d.claimUnique()
+		if err != nil {
+			return err
+		}
+
+		// nothing else holds the values if the save fails
+		defer func() {
+			if err != nil {
+				unclaim()
+			}
+		}()
+
+		// old slugs keep leading to the document
+		if len(oldSlug) > 0 && oldSlug != d.Slug {
+			pipeline.HSet(joinKey([]string{d.Doctype.ID, "slug_history"}), oldSlug, d.ID)
 		}
 	}
 
 	// create, set and Save a new Revision.
 	if d.Revision == nil {
 		d.Revision = CreateRevision(d.ID)
+		d.Revision.ObjectType = "document"
+	} else if d.mergeParent != nil {
+		d.Revision = MergeRevision(d.Revision, d.mergeParent)
+		d.mergeParent = nil
+	} else if len(d.migrated) > 0 {
+		d.Revision = MigrationRevision(d.Revision)
+		d.Revision.Message = "Migrate " + strings.Join(d.migrated, ", ")
+		d.migrated = nil
 	} else {
 		d.Revision = UpdateRevision(d.Revision)
 	}
+	if len(d.promoted) > 0 {
+		d.Revision.Message = fmt.Sprintf("Promote branch '%s'", d.promoted)
+		d.promoted = ""
+	}
 	d.Revision.Save(pipeline)
 
 	// add this revision to a sorted set so we can retrieve all
 	// the revisions on a chronological order.
 	pipeline.ZAdd(joinKey([]string{d.ID, "revisions"}), redis.Z{
-		Score:  float64(d.Revision.When.Unix()),
+		Score:  d.Revision.Score(),
 		Member: d.Revision.ID,
 	})
 
-	// set the current revision the the field's base
-	// hash.
-	pipeline.HSet(d.ID, "revision", d.Revision.ID)
-
-	// make document be foundable by slug
-	// and to make slugs unique
-	pipeline.HSet("documents", d.Slug, d.ID)
-
-	Conn.HSet(d.ID, "type", "document")
+	if d.onBranch() {
+		// a branch only moves it's own head, the document's base
+		// hash keeps pointing to the DefaultBranch.
+		pipeline.HSet(joinKey([]string{d.ID, "branches"}), d.Branch, d.Revision.ID)
+	} else {
+		d.saveHead(pipeline)
+	}
 
 	// Inside this loop there's everything that should be
 	// written to the history of changes (or Revision).
 	// That's why I loop over the Document.ID and Revision.ID
-	for _, baseID := range []string{d.ID, d.Revision.ID} {
+	for _, baseID := range d.baseIDs() {
 		pipeline.HSet(baseID, "slug", d.Slug)
 		pipeline.HSet(baseID, "doctype", d.Doctype.ID)
+		pipeline.HSet(baseID, "doctype_revision", d.DoctypeRevision)
+		pipeline.HSet(baseID, "migration", d.migration)
 	}
 
 	// Loop over fields to save the values to the database.
+	for _, baseID := range d.baseIDs() {
+		d.storeValues(baseID, pipeline)
+	}
+
+	logChange(d.Revision.ID, pipeline)
+	appendChange(d.event(), pipeline)
+
+	_, err = pipeline.Exec()
+	if err != nil {
+		return err
+	}
+
+	publish(d.event())
+
+	return nil
+}
+
+// Delete the document from the database.
+//
+// The document's revisions are kept, so it's history can still be
+// read, but it can't be loaded by it's ID or slug anymore.
+func (d *Document) Delete() error {
+	return intercept(&Operation{Kind: OpDelete, ObjectType: "document", ID: d.ID, Object: d}, d.delete)
+}
+
+func (d *Document) delete() error {
+	var err error
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	if d.Revision == nil {
+		return fmt.Errorf("Document %s can't be deleted, it was never saved", d.ID)
+	}
+
+	unlock, err := lockDocument(d.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if d.Doctype == nil {
+		d.Doctype, err = LoadDoctypeByCode(d.DoctypeCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.Revision = DeleteRevision(d.Revision)
+	d.Revision.Save(pipeline)
+
+	pipeline.ZAdd(joinKey([]string{d.ID, "revisions"}), redis.Z{
+		Score:  d.Revision.Score(),
+		Member: d.Revision.ID,
+	})
+
+	pipeline.HSet(d.Revision.ID, "slug", d.Slug)
+	pipeline.HSet(d.Revision.ID, "doctype", d.Doctype.ID)
+	pipeline.HSet(d.Revision.ID, "doctype_revision", d.DoctypeRevision)
+
+	d.releaseUnique(pipeline)
+	pipeline.SRem(joinKey([]string{d.Doctype.ID, "documents"}), d.ID)
+
+	keys := []string{
+		d.ID,
+		joinKey([]string{d.ID, "values"}),
+		joinKey([]string{d.ID, "extra"}),
+		joinKey([]string{d.ID, "branches"}),
+	}
 	for _, field := range d.Doctype.Fields {
-		d.StoreValue(field, pipeline)
+		keys = append(keys, joinKey([]string{d.ID, "value", field.ID}))
 	}
+	pipeline.Del(keys...)
+
+	logChange(d.Revision.ID, pipeline)
+	appendChange(d.event(), pipeline)
 
 	_, err = pipeline.Exec()
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	publish(d.event())
+
+	return nil
+}
+
+// event describing the last change made to the document.
+func (d *Document) event() Event {
+	return Event{
+		ObjectID:    d.ID,
+		ObjectType:  "document",
+		DoctypeCode: d.Doctype.Code,
+		ChangeType:  d.Revision.Type,
+		Revision:    d.Revision.ID,
+		Branch:      d.Branch,
+		When:        d.Revision.When,
 	}
+}
+
+// saveHead points the document's base hash to the current revision.
+func (d *Document) saveHead(pipeline *redis.Pipeline) {
+	// set the current revision the the field's base
+	// hash.
+	pipeline.HSet(d.ID, "revision", d.Revision.ID)
+
+	pipeline.HSet(d.ID, "type", "document")
 
-	pipeline.Close()
+	// index the document on it's doctype
+	pipeline.SAdd(joinKey([]string{d.Doctype.ID, "documents"}), d.ID)
+}
+
+// onBranch tells if the document lives on a branch other than
+// the DefaultBranch.
+func (d *Document) onBranch() bool {
+	return len(d.Branch) > 0 && d.Branch != DefaultBranch
+}
+
+// baseIDs returns the keys the document's data should be written to.
+// Documents on a branch are written only to their revision so the
+// DefaultBranch stays untouched.
+func (d *Document) baseIDs() []string {
+	if d.onBranch() {
+		return []string{d.Revision.ID}
+	}
+	return []string{d.ID, d.Revision.ID}
 }
 
 // StoreValue of the field to the database.
 func (d *Document) StoreValue(f *Field, pipeline *redis.Pipeline) {
+	for _, baseID := range d.baseIDs() {
+		d.storeValue(f, baseID, pipeline)
+	}
+}
+
+// storeValues of all the fields under the given base key,
+// including the ones unknown to the doctype.
+func (d *Document) storeValues(baseID string, pipeline *redis.Pipeline) {
+	for _, field := range d.Doctype.Fields {
+		d.storeValue(field, baseID, pipeline)
+	}
+
+	d.storeExtra(baseID, pipeline)
+}
+
+// storeValue of the field under the given base key.
+func (d *Document) storeValue(f *Field, baseID string, pipeline *redis.Pipeline) {
 	value := d.Fields[f.Code]
 
-	// Inside this loop there's everything that should be
-	// written to the history of changes (or Revision).
-	// That's why I loop over the Document.ID and Revision.ID
-	for _, baseID := range []string{d.ID, d.Revision.ID} {
-		baseKeyHSet := joinKey([]string{baseID, "values"})
-		//baseKey := joinKey([]string{baseID, "value", f.ID})
+	baseKeyHSet := joinKey([]string{baseID, "values"})
+	baseKey := joinKey([]string{baseID, "value", f.ID})
+
+	// choose the right Redi's type to save the value
+	// and also save space on memory.
+	if !f.inSet() {
+		if value == nil {
+			pipeline.HDel(baseKeyHSet, f.ID)
+		} else {
+			pipeline.HSet(baseKeyHSet, f.ID, f.encodeValue(value))
+		}
+	} else {
+		// multiple values are kept on a set, so they
+		// are replaced as a whole.
+		pipeline.Del(baseKey)
 
-		// choose the right Redi's type to save the value
-		// and also save space on memory.
-		if f.MultipleValues == false {
-			fieldType := f.ExpectedTypes[0]
+		values := []string{}
+		for _, v := range toSlice(value) {
+			values = append(values, f.encodeValue(v))
+		}
 
-			if fieldType == "string" {
-				pipeline.HSet(baseKeyHSet, f.ID, value.(string))
-			}
+		if len(values) > 0 {
+			pipeline.SAdd(baseKey, values...)
 		}
 	}
 }
 
+// storeExtra saves the values not defined by the doctype,
+// so lenient doctypes don't lose them.
+func (d *Document) storeExtra(baseID string, pipeline *redis.Pipeline) {
+	baseKey := joinKey([]string{baseID, "extra"})
+	pipeline.Del(baseKey)
+
+	for code, value := range d.Fields {
+		if _, known := d.Doctype.Fields[code]; known {
+			continue
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			panic(err)
+		}
+
+		pipeline.HSet(baseKey, code, string(encoded))
+	}
+}
+
 // LoadValue of the field to the database.
 func (d *Document) LoadValue(f *Field) {
-	// get all basic information from base hash
-	baseHSet := Conn.HGetAllMap(joinKey([]string{d.ID, "values"})).Val()
-	//baseKey := joinKey([]string{baseID, "value", f.ID})
+	d.loadValue(f, d.baseIDs()[0])
+}
+
+// loadValues of all the fields from the given base key,
+// including the ones unknown to the doctype.
+func (d *Document) loadValues(baseID string) error {
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
 
-	// load from redis based on field's type
-	if f.MultipleValues == false {
-		fieldType := f.ExpectedTypes[0]
+	set := d.queueValues(baseID, pipeline)
 
-		if fieldType == "string" {
-			d.Fields[f.Code] = baseHSet[f.ID]
+	_, err := pipeline.Exec()
+	if err != nil {
+		return err
+	}
+
+	return set()
+}
+
+// queueValues queues on the pipeline the reads of all the values from
+// the given base key. The returned function sets them on the document
+// once the pipeline is executed.
+func (d *Document) queueValues(baseID string, pipeline *redis.Pipeline) func() error {
+	values := pipeline.HGetAllMap(joinKey([]string{baseID, "values"}))
+	extra := pipeline.HGetAllMap(joinKey([]string{baseID, "extra"}))
+
+	members := make(map[string]*redis.StringSliceCmd)
+	for _, field := range d.Doctype.Fields {
+		if field.inSet() {
+			members[field.ID] = pipeline.SMembers(joinKey([]string{baseID, "value", field.ID}))
 		}
 	}
+
+	return func() error {
+		d.Fields = make(map[string]interface{})
+
+		for _, field := range d.Doctype.Fields {
+			if field.inSet() {
+				d.setValues(field, members[field.ID].Val())
+			} else {
+				d.setValue(field, values.Val())
+			}
+		}
+
+		return d.setExtra(extra.Val())
+	}
+}
+
+// loadValue of the field from the given base key.
+func (d *Document) loadValue(f *Field, baseID string) {
+	if f.inSet() {
+		d.setValues(f, Conn.SMembers(joinKey([]string{baseID, "value", f.ID})).Val())
+	} else {
+		// only the field's value is read, not the whole hash
+		values := make(map[string]string)
+		encoded, err := Conn.HGet(joinKey([]string{baseID, "values"}), f.ID).Result()
+		if err == nil {
+			values[f.ID] = encoded
+		}
+		d.setValue(f, values)
+	}
+}
+
+// setValue of a single value field from the values hash.
+func (d *Document) setValue(f *Field, values map[string]string) {
+	encoded, ok := values[f.ID]
+	if !ok && !f.onlyStrings() {
+		d.Fields[f.Code] = nil
+		return
+	}
+
+	d.Fields[f.Code] = f.decodeValue(encoded)
+}
+
+// setValues of a multiple values field from it's set's members.
+func (d *Document) setValues(f *Field, encoded []string) {
+	sort.Strings(encoded)
+
+	if f.onlyStrings() {
+		d.Fields[f.Code] = encoded
+		return
+	}
+
+	values := make([]interface{}, 0, len(encoded))
+	for _, v := range encoded {
+		values = append(values, f.decodeValue(v))
+	}
+	d.Fields[f.Code] = values
+}
+
+// setExtra sets the values not defined by the doctype.
+func (d *Document) setExtra(extra map[string]string) error {
+	for code, encoded := range extra {
+		var value interface{}
+
+		err := json.Unmarshal([]byte(encoded), &value)
+		if err != nil {
+			return err
+		}
+
+		d.Fields[code] = value
+	}
+
+	return nil
 }
 
 // LoadDocumentByID loads a document from the database by ID
 func LoadDocumentByID(id string) (*Document, error) {
+	op := &Operation{Kind: OpLoad, ObjectType: "document", ID: id}
+	return loadDocument(op, func() (*Document, error) {
+		d, err := loadDocumentByID(id)
+		if err != nil {
+			return d, err
+		}
+
+		ok, err := d.migrate(true)
+		if err != nil || !ok {
+			return d, err
+		}
+
+		// the document is migrated anyway, saving it only spares the
+		// next loads from migrating it again. Documents changed
+		// meanwhile are saved with the migrations on their next save.
+		err = d.saveMigrated()
+		if err == errDocumentChanged {
+			err = nil
+		}
+		return d, err
+	})
+}
+
+// loadDocument runs a document's load through the interceptors.
+func loadDocument(op *Operation, load func() (*Document, error)) (*Document, error) {
+	err := intercept(op, func() error {
+		d, err := load()
+		op.Object = d
+		return err
+	})
+
+	d, ok := op.Object.(*Document)
+	if !ok {
+		d = &Document{ID: op.ID}
+	}
+
+	return d, err
+}
+
+func loadDocumentByID(id string) (*Document, error) {
 	var err error
 
 	d := &Document{}
@@ -153,7 +564,7 @@ func LoadDocumentByID(id string) (*Document, error) {
 	get := Conn.HGetAllMap(id).Val()
 
 	if len(get) == 0 {
-		return d, fmt.Errorf("Document NotFound for ID: %s", id)
+		return d, &NotFoundError{ObjectType: "document", By: "ID", Keys: []string{id}}
 	}
 
 	if get["type"] != "document" {
@@ -161,6 +572,8 @@ func LoadDocumentByID(id string) (*Document, error) {
 	}
 
 	d.Slug = get["slug"]
+	d.migration = get["migration"]
+	d.DoctypeRevision = get["doctype_revision"]
 
 	d.Doctype, err = LoadDoctypeByID(get["doctype"])
 	if err != nil {
@@ -168,48 +581,228 @@ func LoadDocumentByID(id string) (*Document, error) {
 	}
 	d.DoctypeCode = d.Doctype.Code
 
-	d.Revision, err = LoadRevisionByID(get["revision"])
+	// the revision and the values are read on a single round-trip
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	revision := pipeline.HGetAllMap(get["revision"])
+	set := d.queueValues(d.ID, pipeline)
+
+	_, err = pipeline.Exec()
 	if err != nil {
 		return d, err
 	}
 
-	// load fields ids so we can load the fields
-	d.Fields = make(map[string]interface{})
-	for _, field := range d.Doctype.Fields {
-		d.LoadValue(field)
+	if len(revision.Val()) == 0 {
+		return d, &NotFoundError{ObjectType: "revision", By: "ID", Keys: []string{get["revision"]}}
+	}
+
+	d.Revision = &Revision{}
+	err = d.Revision.decode(get["revision"], revision.Val())
+	if err != nil {
+		return d, err
+	}
+
+	err = set()
+
+	return d, err
+}
+
+// LoadDocumentRevision loads a document as it was on the given revision.
+func LoadDocumentRevision(id string, revisionID string) (*Document, error) {
+	op := &Operation{Kind: OpLoad, ObjectType: "document", ID: id}
+	return loadDocument(op, func() (*Document, error) {
+		return loadDocumentRevision(id, revisionID)
+	})
+}
+
+func loadDocumentRevision(id string, revisionID string) (*Document, error) {
+	var err error
+
+	d := &Document{}
+	d.ID = id
+
+	// the revision's hash holds a copy of the document's basic information
+	get := Conn.HGetAllMap(revisionID).Val()
+
+	if len(get) == 0 {
+		return d, &NotFoundError{ObjectType: "revision", By: "ID", Keys: []string{revisionID}}
+	}
+
+	if get["type"] != "revision" {
+		return d, fmt.Errorf("%s is type '%s', expecting 'revision'", revisionID, get["type"])
 	}
 
+	if get["object"] != id {
+		return d, fmt.Errorf("Revision %s doesn't belong to document %s", revisionID, id)
+	}
+
+	d.Slug = get["slug"]
+	d.migration = get["migration"]
+	d.DoctypeRevision = get["doctype_revision"]
+
+	// the revision is loaded with the doctype it was saved against,
+	// or the current one when that's not known or was compacted.
+	if len(d.DoctypeRevision) > 0 {
+		d.Doctype, err = LoadDoctypeRevision(get["doctype"], d.DoctypeRevision)
+	}
+	if len(d.DoctypeRevision) == 0 || IsNotFound(err) {
+		d.Doctype, err = LoadDoctypeByID(get["doctype"])
+	}
+	if err != nil {
+		return d, err
+	}
+	d.DoctypeCode = d.Doctype.Code
+
+	// the revision's hash is the one already read
+	d.Revision = &Revision{}
+	err = d.Revision.decode(revisionID, get)
+	if err != nil {
+		return d, err
+	}
+
+	err = d.loadValues(revisionID)
+
 	return d, err
 }
 
 // Create a Documenter on the database
-func CreateDocument(stru_doc Documenter) *Document {
+//
+// The Documenter's lifecycle hooks are called around the save and
+// any error returned by them aborts it.
+func CreateDocument(stru_doc Documenter) (*Document, error) {
+	err := beforeSave(stru_doc)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := structValues(stru_doc)
+	if err != nil {
+		return nil, err
+	}
+
 	db_doc := &Document{
 		Slug:        stru_doc.Slug(),
 		DoctypeCode: stru_doc.DoctypeCode(),
-		Fields:      FromStructToMap(stru_doc),
+		Fields:      fields,
 	}
 
 	// save documenter to the database
-	db_doc.Save()
+	err = db_doc.Save()
+	if err != nil {
+		return db_doc, err
+	}
 
-	return db_doc
+	afterSave(stru_doc, db_doc)
+
+	return db_doc, nil
 }
 
 // Update a Documenter on the database
-func UpdateDocument(id string, stru_doc Documenter) *Document {
+//
+// The Documenter's lifecycle hooks are called around the save and
+// any error returned by them aborts it.
+func UpdateDocument(id string, stru_doc Documenter) (*Document, error) {
+	err := beforeSave(stru_doc)
+	if err != nil {
+		return nil, err
+	}
+
 	// load the document first
-	documentLoaded, documentLoadedErr := LoadDocumentByID(id)
-	if documentLoadedErr != nil {
-		panic(documentLoadedErr)
+	documentLoaded, err := LoadDocumentByID(id)
+	if err != nil {
+		return documentLoaded, err
 	}
 
 	// update fields
 	documentLoaded.Slug = stru_doc.Slug()
-	documentLoaded.Fields = FromStructToMap(stru_doc)
+	documentLoaded.Fields, err = structValues(stru_doc)
+	if err != nil {
+		return documentLoaded, err
+	}
 
 	// save documenter to the database
-	documentLoaded.Save()
+	err = documentLoaded.Save()
+	if err != nil {
+		return documentLoaded, err
+	}
+
+	afterSave(stru_doc, documentLoaded)
+
+	return documentLoaded, nil
+}
+
+// Delete a Documenter from the database
+//
+// Returning an error from BeforeDelete aborts it.
+func DeleteDocument(id string, stru_doc Documenter) error {
+	if hook, ok := stru_doc.(BeforeDeleter); ok {
+		err := hook.BeforeDelete()
+		if err != nil {
+			return err
+		}
+	}
+
+	documentLoaded, err := LoadDocumentByID(id)
+	if err != nil {
+		return err
+	}
+
+	return documentLoaded.Delete()
+}
+
+// LoadInto loads a document from the database into a Documenter
+//
+// The document's values are decoded into the Documenter's fields by
+// their json tags, the same RegisterDoctype reads. The document must be
+// of the Documenter's doctype, or of one extending it.
+func LoadInto(id string, stru_doc Documenter) (*Document, error) {
+	documentLoaded, err := LoadDocumentByID(id)
+	if err != nil {
+		return documentLoaded, err
+	}
+
+	return documentLoaded, decodeInto(documentLoaded, stru_doc)
+}
+
+// decodeInto decodes the document's values into the Documenter,
+// loading the documents it references, and calls it's AfterLoad.
+func decodeInto(d *Document, stru_doc Documenter) error {
+	return decodeStruct(d, stru_doc, make(map[string]Documenter))
+}
+
+func decodeStruct(d *Document, stru_doc Documenter, loaded map[string]Documenter) error {
+	if !d.Doctype.Extending(stru_doc.DoctypeCode()) {
+		return fmt.Errorf("Document %s is a %s, not a %s", d.ID, d.Doctype.Code, stru_doc.DoctypeCode())
+	}
+	loaded[d.ID] = stru_doc
+
+	// references are IDs on the document, loaded apart
+	values := make(map[string]interface{}, len(d.Fields))
+	for code, value := range d.Fields {
+		values[code] = value
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(stru_doc))
+	for _, structField := range structFields(value.Type()) {
+		if isReferenceType(structField.typ.Type) {
+			delete(values, structField.code)
+		}
+	}
+
+	err := FromMapToStruct(values, stru_doc)
+	if err != nil {
+		return err
+	}
+
+	err = decodeReferences(d.Fields, stru_doc, loaded)
+	if err != nil {
+		return err
+	}
+
+	if hook, ok := stru_doc.(AfterLoader); ok {
+		return hook.AfterLoad(d)
+	}
 
-	return documentLoaded
+	return nil
 }
@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"encoding/json"
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
 	"strings"
 	"testing"
@@ -82,3 +83,31 @@ func TestDocument(t *testing.T) {
 		}, ShouldPanic)
 	})
 }
+
+// Loading a document takes the same round-trips whatever it's number of
+// fields, so the time per load should barely grow with them.
+func BenchmarkLoadDocumentByID(b *testing.B) {
+	for _, fields := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("fields=%d", fields), func(b *testing.B) {
+			_, document := benchmarkDoctype(b, fields)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := LoadDocumentByID(document.ID)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadValue(b *testing.B) {
+	d, document := benchmarkDoctype(b, 100)
+	field := d.Fields["field_0"]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		document.LoadValue(field)
+	}
+}
@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/redis.v3"
+	"sync"
+	"time"
+)
+
+// Event is published whenever a change is committed to the database.
+type Event struct {
+	// Object changed and it's type, like doctype or document
+	ObjectID   string `json:"object_id"`
+	ObjectType string `json:"object_type"`
+
+	// Code of the doctype changed, or of the changed document's doctype
+	DoctypeCode string `json:"doctype"`
+
+	// Kind of change, like create, update or delete
+	ChangeType string `json:"change_type"`
+
+	// Revision made by the change
+	Revision string    `json:"revision"`
+	Branch   string    `json:"branch,omitempty"`
+	When     time.Time `json:"when"`
+}
+
+// channel the event is published to.
+func (e Event) channel() string {
+	return joinKey([]string{"events", e.ObjectType, e.DoctypeCode, e.ObjectID})
+}
+
+// EventFilter selects which events a subscription receives.
+// Empty attributes match anything.
+type EventFilter struct {
+	ObjectType  string
+	DoctypeCode string
+	ObjectID    string
+}
+
+// pattern of the channels matching the filter.
+func (f EventFilter) pattern() string {
+	parts := []string{"events", f.ObjectType, f.DoctypeCode, f.ObjectID}
+	for i, part := range parts {
+		if len(part) == 0 {
+			parts[i] = "*"
+		}
+	}
+	return joinKey(parts)
+}
+
+// Subscription delivers the events matching it's filter.
+type Subscription struct {
+	// Events received, closed when the subscription is closed.
+	Events <-chan Event
+
+	pubsub *redis.PubSub
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Subscribe to the events matching the filter. It returns once the
+// subscription is confirmed, so no event published after is missed.
+func Subscribe(filter EventFilter) (*Subscription, error) {
+	pubsub, err := Conn.PSubscribe(filter.pattern())
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := pubsub.ReceiveTimeout(5 * time.Second)
+	if err == nil {
+		if _, ok := reply.(*redis.Subscription); !ok {
+			err = fmt.Errorf("Unexpected reply subscribing to %s: %v", filter.pattern(), reply)
+		}
+	}
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	s := &Subscription{
+		Events: events,
+		pubsub: pubsub,
+		closed: make(chan struct{}),
+	}
+
+	go s.receive(events)
+
+	return s, nil
+}
+
+// Close the subscription. Closing it again does nothing.
+func (s *Subscription) Close() error {
+	var err error
+
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.pubsub.Close()
+	})
+
+	return err
+}
+
+// receive messages until the subscription is closed.
+func (s *Subscription) receive(events chan<- Event) {
+	defer close(events)
+
+	for {
+		msg, err := s.pubsub.ReceiveMessage()
+		if err != nil {
+			return
+		}
+
+		e := Event{}
+		if json.Unmarshal([]byte(msg.Payload), &e) != nil {
+			continue
+		}
+
+		select {
+		case events <- e:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// publish the event to it's subscribers.
+// It's done after the change is committed and, as nobody may be
+// listening, failures are ignored.
+func publish(e Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	Conn.Publish(e.channel(), string(payload))
+}
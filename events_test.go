@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	Convey("Subscribe to a doctype's documents", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "message",
+			VerboseName: "Message",
+			Fields: map[string]*Field{
+				"text": {VerboseName: "Text", ExpectedTypes: []string{"string"}},
+			},
+		}
+		doctypeCreated.Save()
+
+		subscription, subscribeErr := Subscribe(EventFilter{ObjectType: "document", DoctypeCode: "message"})
+		if subscribeErr != nil {
+			panic(subscribeErr)
+		}
+		defer subscription.Close()
+
+		receive := func() Event {
+			select {
+			case e := <-subscription.Events:
+				return e
+			case <-time.After(time.Second):
+				panic("no event received")
+			}
+		}
+
+		documentCreated := &Document{
+			Slug:        "notified-message",
+			DoctypeCode: "message",
+			Fields:      map[string]interface{}{"text": "Hello"},
+		}
+		documentCreated.Save()
+
+		Convey("Receive creation and deletion", func() {
+			created := receive()
+			So(created.ObjectID, ShouldEqual, documentCreated.ID)
+			So(created.ChangeType, ShouldEqual, "create")
+			So(created.Revision, ShouldEqual, documentCreated.Revision.ID)
+
+			documentCreated.Delete()
+
+			deleted := receive()
+			So(deleted.ObjectID, ShouldEqual, documentCreated.ID)
+			So(deleted.ChangeType, ShouldEqual, "delete")
+
+			_, loadErr := LoadDocumentByID(documentCreated.ID)
+			So(loadErr, ShouldNotBeNil)
+		})
+
+		Convey("Close the subscription twice", func() {
+			So(subscription.Close(), ShouldBeNil)
+			So(func() { subscription.Close() }, ShouldNotPanic)
+		})
+
+		Convey("Documents never saved can't be deleted", func() {
+			So((&Document{DoctypeCode: "message"}).Delete(), ShouldNotBeNil)
+		})
+	})
+}
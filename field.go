@@ -1,10 +1,18 @@
 package datastore
 
 import (
+	"encoding/json"
 	"gopkg.in/redis.v3"
 	"strconv"
 )
 
+// Names of the optional settings on the field's hash.
+var fieldOptions = []string{
+	"required", "unique", "pattern", "enum", "min", "max",
+	"min_length", "max_length", "min_items", "max_items",
+	"default", "default_generator", "computed", "deprecated", "fields",
+}
+
 // Field represents of a field of a Doctype
 type Field struct {
 	// ID used on the internals of the database.
@@ -28,6 +36,43 @@ type Field struct {
 	// Usefull for things like tags or categories.
 	MultipleValues bool `json:"multiple_values"`
 
+	// Validation rules, checked whenever a document is saved.
+	// When the field has multiple values, they're checked on each one.
+	Required  bool          `json:"required,omitempty"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+	MinLength *int          `json:"min_length,omitempty"`
+	MaxLength *int          `json:"max_length,omitempty"`
+	Pattern   string        `json:"pattern,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	Unique    bool          `json:"unique,omitempty"`
+
+	// Number of values accepted by multiple values fields.
+	MinItems *int `json:"min_items,omitempty"`
+	MaxItems *int `json:"max_items,omitempty"`
+
+	// Value set when a document is created without one. It can also be
+	// generated, by one of the DefaultGenerators.
+	Default          interface{} `json:"default,omitempty"`
+	DefaultGenerator string      `json:"default_generator,omitempty"`
+
+	// Name of the function, registered with RegisterComputed, that
+	// sets the field's value whenever the document is saved.
+	Computed string `json:"computed,omitempty"`
+
+	// Deprecated fields are kept so the values documents already have
+	// stay readable, but they're no longer required, defaulted or
+	// computed.
+	Deprecated bool `json:"deprecated,omitempty"`
+
+	// Definitions of the values of sub-documents, for fields expecting
+	// objects. Sub-documents are versioned with the document.
+	Fields map[string]*Field `json:"fields,omitempty"`
+
+	// Code of the doctype the field is inherited from, empty for the
+	// doctype's own fields.
+	InheritedFrom string `json:"inherited_from,omitempty"`
+
 	// Last revision of the field.
 	Revision *Revision `json:"revision"`
 }
@@ -46,7 +91,7 @@ func (f *Field) Save(doctype *Doctype, pipeline *redis.Pipeline) {
 	// add this revision to a sorted set so we can retrieve all
 	// the revisions on a chronological order.
 	pipeline.ZAdd(joinKey([]string{baseKey, "revisions"}), redis.Z{
-		Score:  float64(f.Revision.When.Unix()),
+		Score:  f.Revision.Score(),
 		Member: f.Revision.ID,
 	})
 
@@ -70,40 +115,249 @@ func (f *Field) Save(doctype *Doctype, pipeline *redis.Pipeline) {
 		pipeline.HSet(baseKey, "code", f.Code)
 		pipeline.HSet(baseKey, "multiple_values", strconv.FormatBool(f.MultipleValues))
 
+		// replace the expected types the field had before
+		pipeline.Del(joinKey([]string{baseKey, "expected_types"}))
 		for _, expectedType := range f.ExpectedTypes {
 			pipeline.SAdd(joinKey([]string{baseKey, "expected_types"}), expectedType)
 		}
+
+		f.saveOptions(baseKey, pipeline)
 	}
 }
 
-// LoadFieldByID loads a doctype's field's definition from the database by ID
-func LoadFieldByID(d *Doctype, id string) {
+// saveOptions saves the validation rules and the default and computed
+// settings to the field's hash. Options not set are removed, so they're
+// not loaded back.
+func (f *Field) saveOptions(baseKey string, pipeline *redis.Pipeline) {
+	options := map[string]string{}
+
+	if f.Required {
+		options["required"] = strconv.FormatBool(f.Required)
+	}
+	if f.Unique {
+		options["unique"] = strconv.FormatBool(f.Unique)
+	}
+	if f.Deprecated {
+		options["deprecated"] = strconv.FormatBool(f.Deprecated)
+	}
+	if len(f.Pattern) > 0 {
+		options["pattern"] = f.Pattern
+	}
+	if len(f.Enum) > 0 {
+		enum, err := json.Marshal(f.Enum)
+		if err != nil {
+			panic(err)
+		}
+		options["enum"] = string(enum)
+	}
+	if f.Default != nil {
+		value, err := json.Marshal(f.Default)
+		if err != nil {
+			panic(err)
+		}
+		options["default"] = string(value)
+	}
+	if len(f.DefaultGenerator) > 0 {
+		options["default_generator"] = f.DefaultGenerator
+	}
+	if len(f.Computed) > 0 {
+		options["computed"] = f.Computed
+	}
+	if len(f.Fields) > 0 {
+		for code, field := range f.Fields {
+			field.Code = code
+		}
+
+		fields, err := json.Marshal(f.Fields)
+		if err != nil {
+			panic(err)
+		}
+		options["fields"] = string(fields)
+	}
+
+	for name, value := range map[string]*float64{"min": f.Min, "max": f.Max} {
+		if value != nil {
+			options[name] = strconv.FormatFloat(*value, 'f', -1, 64)
+		}
+	}
+
+	for name, value := range map[string]*int{
+		"min_length": f.MinLength,
+		"max_length": f.MaxLength,
+		"min_items":  f.MinItems,
+		"max_items":  f.MaxItems,
+	} {
+		if value != nil {
+			options[name] = strconv.Itoa(*value)
+		}
+	}
+
+	for _, name := range fieldOptions {
+		if value, ok := options[name]; ok {
+			pipeline.HSet(baseKey, name, value)
+		} else {
+			pipeline.HDel(baseKey, name)
+		}
+	}
+}
+
+// loadOptions loads the validation rules and the default and computed
+// settings from the field's hash.
+func (f *Field) loadOptions(get map[string]string) error {
 	var err error
 
-	f := &Field{}
-	f.ID = id
+	f.Required = get["required"] == "true"
+	f.Unique = get["unique"] == "true"
+	f.Deprecated = get["deprecated"] == "true"
+	f.Pattern = get["pattern"]
 
-	// make base field's key
-	baseKey := joinKey([]string{d.ID, "field", f.ID})
+	if len(get["enum"]) > 0 {
+		err = json.Unmarshal([]byte(get["enum"]), &f.Enum)
+		if err != nil {
+			return err
+		}
+	}
 
-	// get all basic information from base hash
-	get := Conn.HGetAllMap(baseKey).Val()
+	if len(get["default"]) > 0 {
+		err = json.Unmarshal([]byte(get["default"]), &f.Default)
+		if err != nil {
+			return err
+		}
+	}
+	f.DefaultGenerator = get["default_generator"]
+	f.Computed = get["computed"]
 
-	f.Code = get["code"]
-	f.VerboseName = get["verbose_name"]
+	if len(get["fields"]) > 0 {
+		err = json.Unmarshal([]byte(get["fields"]), &f.Fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, value := range map[string]**float64{"min": &f.Min, "max": &f.Max} {
+		if len(get[name]) > 0 {
+			number, err := strconv.ParseFloat(get[name], 64)
+			if err != nil {
+				return err
+			}
+			*value = &number
+		}
+	}
 
-	f.MultipleValues, err = strconv.ParseBool(get["multiple_values"])
+	for name, value := range map[string]**int{
+		"min_length": &f.MinLength,
+		"max_length": &f.MaxLength,
+		"min_items":  &f.MinItems,
+		"max_items":  &f.MaxItems,
+	} {
+		if len(get[name]) > 0 {
+			number, err := strconv.Atoi(get[name])
+			if err != nil {
+				return err
+			}
+			*value = &number
+		}
+	}
+
+	return nil
+}
+
+// LoadFieldByID loads a doctype's field's definition from the database by ID
+func LoadFieldByID(d *Doctype, id string) {
+	err := d.loadFields(d.ID, []string{id}, nil)
 	if err != nil {
 		panic(err)
 	}
+}
+
+// loadFields loads the fields' definitions from the given base key, the
+// doctype's ID or one of it's revisions, into the doctype.
+//
+// revisions holds the revisions already loaded, by ID, and is given the
+// ones the fields were last changed on. The ones mapped to nil are
+// loaded too. The reads are pipelined, so it takes at most two
+// round-trips however many fields there are.
+func (d *Doctype) loadFields(baseID string, ids []string, revisions map[string]*Revision) error {
+	var err error
+
+	if revisions == nil {
+		revisions = make(map[string]*Revision)
+	}
+	if d.Revision != nil {
+		revisions[d.Revision.ID] = d.Revision
+	}
+
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	expectedTypes := make([]*redis.StringSliceCmd, len(ids))
+
+	if len(ids) > 0 {
+		pipeline := Conn.Pipeline()
+		defer pipeline.Close()
+
+		for i, id := range ids {
+			// make base field's key
+			baseKey := joinKey([]string{baseID, "field", id})
+
+			hashes[i] = pipeline.HGetAllMap(baseKey)
+			expectedTypes[i] = pipeline.SMembers(joinKey([]string{baseKey, "expected_types"}))
+		}
+
+		_, err = pipeline.Exec()
+		if err != nil {
+			return err
+		}
+	}
 
-	f.Revision, err = LoadRevisionByID(get["revision"])
+	// only the fields' base hashes point to their revisions,
+	// on a doctype's revision it's the revision itself.
+	for _, hash := range hashes {
+		revisionID := hash.Val()["revision"]
+		if _, ok := revisions[revisionID]; !ok && len(revisionID) > 0 {
+			revisions[revisionID] = nil
+		}
+	}
+
+	revisionIDs := []string{}
+	for id, r := range revisions {
+		if r == nil {
+			revisionIDs = append(revisionIDs, id)
+		}
+	}
+
+	err = loadRevisions(revisionIDs, revisions)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	f.ExpectedTypes = Conn.SMembers(joinKey([]string{baseKey, "expected_types"})).Val()
+	for i, id := range ids {
+		get := hashes[i].Val()
+
+		f := &Field{}
+		f.ID = id
+		f.Code = get["code"]
+		f.VerboseName = get["verbose_name"]
+
+		f.MultipleValues, err = strconv.ParseBool(get["multiple_values"])
+		if err != nil {
+			return err
+		}
+
+		if len(get["revision"]) > 0 {
+			f.Revision = revisions[get["revision"]]
+		} else {
+			f.Revision = d.Revision
+		}
+
+		f.ExpectedTypes = expectedTypes[i].Val()
+
+		err = f.loadOptions(get)
+		if err != nil {
+			return err
+		}
+
+		// add field to doctype's instance fields definitions
+		d.Fields[f.Code] = f
+	}
 
-	// add field to doctype's instance fields definitions
-	d.Fields[f.Code] = f
+	return nil
 }
@@ -0,0 +1,17 @@
+module github.com/levitar/datastore
+
+go 1.22
+
+require (
+	github.com/Sirupsen/logrus v0.11.5
+	github.com/smartystreets/goconvey v1.8.1
+	gopkg.in/redis.v3 v3.6.4
+)
+
+require (
+	github.com/gopherjs/gopherjs v1.17.2 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/smarty/assertions v1.15.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+	gopkg.in/bsm/ratelimit.v1 v1.0.0-20170922094635-f56db5e73a5e // indirect
+)
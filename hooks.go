@@ -0,0 +1,54 @@
+package datastore
+
+// Optional interfaces a Documenter can implement to be called on
+// it's lifecycle by CreateDocument, UpdateDocument, DeleteDocument
+// and LoadInto.
+
+// Validator checks the Documenter before it's saved.
+type Validator interface {
+	Validate() error
+}
+
+// BeforeSaver is called before the Documenter is saved,
+// after it's validated.
+type BeforeSaver interface {
+	BeforeSave() error
+}
+
+// AfterSaver is called after the Documenter is saved.
+type AfterSaver interface {
+	AfterSave(d *Document)
+}
+
+// BeforeDeleter is called before the Documenter is deleted.
+type BeforeDeleter interface {
+	BeforeDelete() error
+}
+
+// AfterLoader is called after the Documenter is loaded.
+type AfterLoader interface {
+	AfterLoad(d *Document) error
+}
+
+// beforeSave validates the Documenter and calls it's BeforeSave.
+func beforeSave(stru_doc Documenter) error {
+	if hook, ok := stru_doc.(Validator); ok {
+		err := hook.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := stru_doc.(BeforeSaver); ok {
+		return hook.BeforeSave()
+	}
+
+	return nil
+}
+
+// afterSave calls the Documenter's AfterSave.
+func afterSave(stru_doc Documenter, d *Document) {
+	if hook, ok := stru_doc.(AfterSaver); ok {
+		hook.AfterSave(d)
+	}
+}
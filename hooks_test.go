@@ -0,0 +1,87 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type Comment struct {
+	Code   string   `json:"code"`
+	Text   string   `json:"text"`
+	Called []string `json:"-"`
+}
+
+func (c *Comment) Slug() string {
+	return fmt.Sprint(c.DoctypeCode(), "/", c.Code)
+}
+
+func (c *Comment) DoctypeCode() string {
+	return "comment"
+}
+
+func (c *Comment) Validate() error {
+	c.Called = append(c.Called, "Validate")
+	if len(c.Text) == 0 {
+		return errors.New("Comment without text")
+	}
+	return nil
+}
+
+func (c *Comment) BeforeSave() error {
+	c.Called = append(c.Called, "BeforeSave")
+	return nil
+}
+
+func (c *Comment) AfterSave(d *Document) {
+	c.Called = append(c.Called, "AfterSave")
+}
+
+func (c *Comment) BeforeDelete() error {
+	c.Called = append(c.Called, "BeforeDelete")
+	return nil
+}
+
+func (c *Comment) AfterLoad(d *Document) error {
+	c.Called = append(c.Called, "AfterLoad")
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	Convey("Registering a Documenter with hooks", t, func() {
+		RegisterDoctype(&Comment{})
+
+		Convey("Invalid documents are not saved", func() {
+			comment := &Comment{Code: GenerateID(4)}
+
+			_, err := CreateDocument(comment)
+			So(err, ShouldNotBeNil)
+			So(comment.Called, ShouldResemble, []string{"Validate"})
+		})
+
+		Convey("Hooks are called on it's lifecycle", func() {
+			comment := &Comment{Code: GenerateID(4), Text: "Hooked"}
+
+			documentCreated, err := CreateDocument(comment)
+			if err != nil {
+				panic(err)
+			}
+			So(comment.Called, ShouldResemble, []string{"Validate", "BeforeSave", "AfterSave"})
+
+			commentLoaded := &Comment{}
+			_, err = LoadInto(documentCreated.ID, commentLoaded)
+			if err != nil {
+				panic(err)
+			}
+			So(commentLoaded.Text, ShouldEqual, "Hooked")
+			So(commentLoaded.Called, ShouldResemble, []string{"AfterLoad"})
+
+			err = DeleteDocument(documentCreated.ID, commentLoaded)
+			if err != nil {
+				panic(err)
+			}
+			So(commentLoaded.Called, ShouldResemble, []string{"AfterLoad", "BeforeDelete"})
+		})
+	})
+}
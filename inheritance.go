@@ -0,0 +1,330 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/redis.v3"
+	"sort"
+)
+
+// inherit sets the fields of the doctypes the doctype extends, and of
+// the ones they extend. The doctype's own fields take precedence, then
+// the ones of the first parent and it's ancestors, and so on.
+//
+// Each ancestor's definition is read on it's own round-trips, so loads
+// take longer the more ancestors there are. See LoadDoctypeByID.
+func (d *Doctype) inherit() error {
+	return d.inheritRevisions(nil)
+}
+
+// inheritRevisions is like inherit, but the doctypes of the codes in
+// pinned are inherited as they were on the given revisions instead of
+// as they're now.
+func (d *Doctype) inheritRevisions(pinned map[string]string) error {
+	for code, field := range d.Fields {
+		if len(field.InheritedFrom) > 0 {
+			delete(d.Fields, code)
+		}
+	}
+
+	if len(d.Extends) > 0 && d.Fields == nil {
+		d.Fields = make(map[string]*Field)
+	}
+
+	d.inherited = make(map[string]string)
+
+	return d.inheritFrom(d.Extends, map[string]bool{d.Code: true}, pinned)
+}
+
+// inheritFrom the doctypes of the given codes. visiting holds the
+// doctypes being inherited from, to refuse cycles.
+func (d *Doctype) inheritFrom(codes []string, visiting map[string]bool, pinned map[string]string) error {
+	for _, code := range codes {
+		if visiting[code] {
+			return fmt.Errorf("Doctype %s can't extend %s, it's extended by it", d.Code, code)
+		}
+
+		parent, err := loadDefinition(code, pinned[code])
+		if err != nil {
+			return err
+		}
+
+		if parent.Revision != nil {
+			d.inherited[code] = parent.Revision.ID
+		}
+
+		for fieldCode, field := range parent.Fields {
+			if _, ok := d.Fields[fieldCode]; ok {
+				continue
+			}
+
+			inherited := *field
+			inherited.InheritedFrom = parent.Code
+			d.Fields[fieldCode] = &inherited
+		}
+
+		visiting[code] = true
+		err = d.inheritFrom(parent.Extends, visiting, pinned)
+		if err != nil {
+			return err
+		}
+		delete(visiting, code)
+	}
+
+	return nil
+}
+
+// loadDefinition loads the doctype's own definition by code, without
+// inheriting fields. When revisionID is set it's loaded as it was on
+// that revision.
+func loadDefinition(code string, revisionID string) (*Doctype, error) {
+	d := &Doctype{Code: code}
+
+	if len(revisionID) > 0 {
+		get, fieldIDs, err := readDefinition(revisionID)
+		if err != nil {
+			return d, err
+		}
+
+		if len(get) == 0 {
+			return d, &NotFoundError{ObjectType: "revision", By: "ID", Keys: []string{revisionID}}
+		}
+
+		d.ID = get["object"]
+		d.Revision = &Revision{}
+		err = d.Revision.decode(revisionID, get)
+		if err != nil {
+			return d, err
+		}
+
+		return d, d.decode(revisionID, get, fieldIDs)
+	}
+
+	d.ID = Conn.HGet("doctypes", code).Val()
+	if len(d.ID) == 0 {
+		return d, &NotFoundError{ObjectType: "doctype", By: "code", Keys: []string{code}}
+	}
+
+	get, fieldIDs, err := readDefinition(d.ID)
+	if err != nil {
+		return d, err
+	}
+
+	return d, d.decode(d.ID, get, fieldIDs)
+}
+
+// generateFieldIDs sets the IDs of the doctype's new fields, not used
+// by any other of it's fields, inherited or not, nor by the fields of
+// the doctypes extending it. Values are stored by the field's ID, so
+// they can't be repeated on any doctype the fields end up on.
+func (d *Doctype) generateFieldIDs() error {
+	used := make(map[string]string)
+
+	for code, field := range d.Fields {
+		if len(field.ID) == 0 {
+			continue
+		}
+
+		if other, ok := used[field.ID]; ok {
+			return fmt.Errorf("Fields %s and %s of doctype %s have the same ID %s", other, code, d.Code, field.ID)
+		}
+		used[field.ID] = code
+	}
+
+	subtypes, err := Subtypes(d.Code)
+	if err != nil {
+		return err
+	}
+
+	for _, subtypeCode := range subtypes {
+		subtype, err := LoadDoctypeByCode(subtypeCode)
+		if err != nil {
+			return err
+		}
+
+		for code, field := range subtype.Fields {
+			// inherited from this doctype
+			if own, ok := d.Fields[code]; ok && own.ID == field.ID {
+				continue
+			}
+
+			if other, ok := used[field.ID]; ok {
+				return fmt.Errorf("Field %s of doctype %s has the same ID %s of field %s of it's subtype %s", other, d.Code, field.ID, code, subtypeCode)
+			}
+			used[field.ID] = subtypeCode + "." + code
+		}
+	}
+
+	for code, field := range d.Fields {
+		for len(field.ID) == 0 {
+			id := GenerateID(2)
+			if _, ok := used[id]; !ok {
+				field.ID = id
+				used[id] = code
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extending tells if the doctype is or extends, directly or not, the
+// doctype of the given code.
+func (d *Doctype) Extending(code string) bool {
+	return d.Code == code || extends(d.Extends, code, map[string]bool{d.Code: true})
+}
+
+// extends tells if any of the doctypes of the given codes is or extends
+// the ancestor.
+func extends(codes []string, ancestor string, visited map[string]bool) bool {
+	for _, code := range codes {
+		if code == ancestor {
+			return true
+		}
+
+		if visited[code] {
+			continue
+		}
+		visited[code] = true
+
+		if extends(parentsOf(code), ancestor, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parentsOf returns the codes of the doctypes the doctype of the given
+// code extends.
+func parentsOf(code string) []string {
+	var parents []string
+
+	doctypeID := Conn.HGet("doctypes", code).Val()
+	if len(doctypeID) == 0 {
+		return parents
+	}
+
+	encoded := Conn.HGet(doctypeID, "extends").Val()
+	if len(encoded) > 0 {
+		json.Unmarshal([]byte(encoded), &parents)
+	}
+
+	return parents
+}
+
+// Subtypes returns the codes of the doctypes extending the doctype,
+// directly or not.
+func Subtypes(code string) ([]string, error) {
+	subtypes := []string{}
+
+	doctypes, err := Conn.HGetAllMap("doctypes").Result()
+	if err != nil {
+		return subtypes, err
+	}
+
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	parents := make(map[string]*redis.StringCmd, len(doctypes))
+	for doctypeCode, id := range doctypes {
+		parents[doctypeCode] = pipeline.HGet(id, "extends")
+	}
+
+	_, err = pipeline.Exec()
+	if err != nil && err != redis.Nil {
+		return subtypes, err
+	}
+
+	children := make(map[string][]string)
+	for doctypeCode, cmd := range parents {
+		var extends []string
+		if len(cmd.Val()) > 0 {
+			err = json.Unmarshal([]byte(cmd.Val()), &extends)
+			if err != nil {
+				return subtypes, err
+			}
+		}
+
+		for _, parent := range extends {
+			children[parent] = append(children[parent], doctypeCode)
+		}
+	}
+
+	found := map[string]bool{code: true}
+	queue := []string{code}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, child := range children[parent] {
+			if !found[child] {
+				found[child] = true
+				subtypes = append(subtypes, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	sort.Strings(subtypes)
+
+	return subtypes, nil
+}
+
+// DocumentIDs lists the IDs of the documents of the doctype, and of
+// the doctypes extending it when withSubtypes is set.
+func DocumentIDs(doctypeCode string, withSubtypes bool) ([]string, error) {
+	var ids []string
+
+	op := &Operation{Kind: OpQuery, ObjectType: "document"}
+	err := intercept(op, func() (err error) {
+		ids, err = documentIDs(doctypeCode, withSubtypes)
+		op.Object = ids
+		return err
+	})
+
+	if result, ok := op.Object.([]string); ok {
+		ids = result
+	}
+
+	return ids, err
+}
+
+func documentIDs(doctypeCode string, withSubtypes bool) ([]string, error) {
+	ids := []string{}
+	codes := []string{doctypeCode}
+
+	if withSubtypes {
+		subtypes, err := Subtypes(doctypeCode)
+		if err != nil {
+			return ids, err
+		}
+		codes = append(codes, subtypes...)
+	}
+
+	for _, code := range codes {
+		doctypeID := Conn.HGet("doctypes", code).Val()
+		if len(doctypeID) == 0 {
+			return ids, &NotFoundError{ObjectType: "doctype", By: "code", Keys: []string{code}}
+		}
+
+		members, err := Conn.SMembers(joinKey([]string{doctypeID, "documents"})).Result()
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, members...)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// LoadDocumentsOf loads all the documents of the doctype, and of the
+// doctypes extending it when withSubtypes is set.
+func LoadDocumentsOf(doctypeCode string, withSubtypes bool) ([]*Document, error) {
+	ids, err := DocumentIDs(doctypeCode, withSubtypes)
+	if err != nil {
+		return []*Document{}, err
+	}
+
+	return LoadDocuments(ids...)
+}
@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestInheritance(t *testing.T) {
+	Convey("Create a doctype and another extending it", t, func() {
+		content := &Doctype{
+			Code:        "content",
+			VerboseName: "Content",
+			Fields: map[string]*Field{
+				"title": {VerboseName: "Title", ExpectedTypes: []string{"string"}, Required: true},
+			},
+		}
+		So(content.Save(), ShouldBeNil)
+
+		article := &Doctype{
+			Code:        "article",
+			VerboseName: "Article",
+			Extends:     []string{"content"},
+			Fields: map[string]*Field{
+				"body": {VerboseName: "Body", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(article.Save(), ShouldBeNil)
+
+		Convey("Fields are inherited", func() {
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByCode("article")
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields, ShouldContainKey, "body")
+			So(doctypeLoaded.Fields["title"].InheritedFrom, ShouldEqual, "content")
+			So(doctypeLoaded.Fields["title"].ID, ShouldEqual, content.Fields["title"].ID)
+			So(doctypeLoaded.Extending("content"), ShouldBeTrue)
+
+			// inherited fields are validated and stored
+			invalid := &Document{DoctypeCode: "article", Slug: "untitled", Fields: map[string]interface{}{"body": "..."}}
+			So(invalid.Save(), ShouldNotBeNil)
+
+			documentCreated := &Document{DoctypeCode: "article", Slug: "hello", Fields: map[string]interface{}{"title": "Hello", "body": "..."}}
+			So(documentCreated.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["title"], ShouldEqual, "Hello")
+		})
+
+		Convey("Parents' changes reach the subtypes", func() {
+			So(content.AddField("summary", &Field{VerboseName: "Summary", ExpectedTypes: []string{"string"}}), ShouldBeNil)
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByCode("article")
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields["summary"].InheritedFrom, ShouldEqual, "content")
+		})
+
+		Convey("Older revisions keep the parents' fields of their time", func() {
+			pinned := article.Revision.ID
+			So(content.AddField("summary", &Field{VerboseName: "Summary", ExpectedTypes: []string{"string"}}), ShouldBeNil)
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeRevision(article.ID, pinned)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields, ShouldContainKey, "title")
+			So(doctypeLoaded.Fields, ShouldNotContainKey, "summary")
+		})
+
+		Convey("Parents' fields can't take the IDs of the subtypes' fields", func() {
+			err := content.AddField("text", &Field{ID: article.Fields["body"].ID, VerboseName: "Text", ExpectedTypes: []string{"string"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Cycles are refused", func() {
+			content.Extends = []string{"article"}
+			So(content.Save(), ShouldNotBeNil)
+		})
+
+		Convey("Query documents of a doctype and it's subtypes", func() {
+			page := &Document{DoctypeCode: "content", Slug: "about", Fields: map[string]interface{}{"title": "About"}}
+			So(page.Save(), ShouldBeNil)
+
+			post := &Document{DoctypeCode: "article", Slug: "news", Fields: map[string]interface{}{"title": "News"}}
+			So(post.Save(), ShouldBeNil)
+
+			subtypes, subtypesErr := Subtypes("content")
+			if subtypesErr != nil {
+				panic(subtypesErr)
+			}
+			So(subtypes, ShouldContain, "article")
+
+			ids, idsErr := DocumentIDs("content", false)
+			if idsErr != nil {
+				panic(idsErr)
+			}
+			So(ids, ShouldResemble, []string{page.ID})
+
+			documents, documentsErr := LoadDocumentsOf("content", true)
+			if documentsErr != nil {
+				panic(documentsErr)
+			}
+			So(len(documents), ShouldEqual, 2)
+		})
+
+		Convey("References accept subtypes", func() {
+			post := &Document{DoctypeCode: "article", Slug: "linked", Fields: map[string]interface{}{"title": "Linked"}}
+			So(post.Save(), ShouldBeNil)
+
+			related := &Field{ExpectedTypes: []string{"content"}}
+			So(related.accepts(post.ID), ShouldBeTrue)
+		})
+	})
+}
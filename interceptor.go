@@ -0,0 +1,126 @@
+package datastore
+
+import (
+	"sync"
+)
+
+// Kinds of operations passed to the interceptors
+const (
+	OpSave    = "save"
+	OpLoad    = "load"
+	OpDelete  = "delete"
+	OpQuery   = "query"
+	OpCompact = "compact"
+	OpMigrate = "migrate"
+)
+
+// Operation done on the datastore.
+//
+// The operations going through the interceptors are, by kind:
+//
+//	OpSave     Doctype.Save, SyncDoctype and RegisterDoctype,
+//	           Document.Save, PromoteBranch and Webhook.Save
+//	OpLoad     LoadDoctypeByID, LoadDoctypeByCode, LoadDoctypeRevision,
+//	           LoadDocumentByID, LoadDocumentBySlug, LoadDocumentRevision
+//	           and LoadDocumentBranch
+//	OpDelete   Document.Delete and Webhook.Delete
+//	OpQuery    LoadDocuments, DocumentIDs, LoadDocumentsOf and Changes
+//	OpCompact  Compact, once for each object compacted by CompactAll
+//	OpMigrate  Migrate
+//
+// Operations made of others go through them as those, like Merge and
+// MergeBranches through their loads and save, and Migrate through the
+// saves of the documents migrated too. Anything else, like reading
+// revisions, tags, streams and subscriptions, doesn't.
+type Operation struct {
+	// Kind of operation, one of the Op constants
+	Kind string
+
+	// Type of the object, like doctype or document
+	ObjectType string
+
+	// ID of the object, when known
+	ID string
+
+	// Object being saved or deleted. On loads and queries it's set
+	// with the result, so interceptors can read it after calling next,
+	// or set it and skip next altogether, like a cache would.
+	Object interface{}
+}
+
+// Interceptor wraps an operation. It must call next to carry the
+// operation on, and can abort it by returning an error instead.
+type Interceptor func(op *Operation, next func() error) error
+
+// registered interceptors wrap every operation, the first being the
+// outermost. The list is replaced on changes, never changed in place, so
+// operations run through the one they read without holding the lock.
+var (
+	interceptors      []*registered
+	interceptorsMutex sync.RWMutex
+)
+
+type registered struct {
+	interceptor Interceptor
+}
+
+// Use registers interceptors to wrap every operation.
+// Operations already running keep the interceptors they started with.
+// Returns a function removing the interceptors registered.
+func Use(fns ...Interceptor) (remove func()) {
+	added := make([]*registered, 0, len(fns))
+	for _, fn := range fns {
+		added = append(added, &registered{fn})
+	}
+
+	interceptorsMutex.Lock()
+	defer interceptorsMutex.Unlock()
+
+	// the full slice expression makes append copy the list
+	interceptors = append(interceptors[:len(interceptors):len(interceptors)], added...)
+
+	return func() {
+		interceptorsMutex.Lock()
+		defer interceptorsMutex.Unlock()
+
+		removed := make(map[*registered]bool, len(added))
+		for _, r := range added {
+			removed[r] = true
+		}
+
+		kept := []*registered{}
+		for _, r := range interceptors {
+			if !removed[r] {
+				kept = append(kept, r)
+			}
+		}
+		interceptors = kept
+	}
+}
+
+// ResetInterceptors removes all the interceptors registered.
+func ResetInterceptors() {
+	interceptorsMutex.Lock()
+	defer interceptorsMutex.Unlock()
+
+	interceptors = nil
+}
+
+// intercept runs the operation through the interceptors.
+func intercept(op *Operation, fn func() error) error {
+	interceptorsMutex.RLock()
+	current := interceptors
+	interceptorsMutex.RUnlock()
+
+	return chain(current, op, fn)
+}
+
+func chain(interceptors []*registered, op *Operation, fn func() error) error {
+	if len(interceptors) == 0 {
+		return fn()
+	}
+
+	return interceptors[0].interceptor(op, func() error {
+		return chain(interceptors[1:], op, fn)
+	})
+}
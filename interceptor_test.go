@@ -0,0 +1,94 @@
+package datastore
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	Convey("Register interceptors", t, func() {
+		operations := []string{}
+		remove := Use(func(op *Operation, next func() error) error {
+			operations = append(operations, op.Kind+" "+op.ObjectType)
+			return next()
+		}, func(op *Operation, next func() error) error {
+			if op.Kind == OpDelete {
+				return errors.New("Not allowed")
+			}
+			return next()
+		})
+		defer remove()
+
+		doctypeCreated := &Doctype{
+			Code:        "intercepted",
+			VerboseName: "Intercepted",
+			Fields: map[string]*Field{
+				"text": {VerboseName: "Text", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		documentCreated := &Document{
+			Slug:        "intercepted-document",
+			DoctypeCode: "intercepted",
+			Fields:      map[string]interface{}{"text": "Hello"},
+		}
+		So(documentCreated.Save(), ShouldBeNil)
+
+		Convey("Operations go through the interceptors", func() {
+			So(operations, ShouldResemble, []string{
+				"save doctype",
+				"save document",
+				"load doctype",
+			})
+		})
+
+		Convey("Every kind of operation goes through them", func() {
+			operations = operations[:0]
+
+			_, err := DocumentIDs("intercepted", false)
+			So(err, ShouldBeNil)
+			So(operations, ShouldContain, "query document")
+
+			_, err = Compact(documentCreated.ID, RetentionPolicy{KeepLast: 10})
+			So(err, ShouldBeNil)
+			So(operations, ShouldContain, "compact document")
+
+			_, err = Migrate("intercepted", 10)
+			So(err, ShouldBeNil)
+			So(operations, ShouldContain, "migrate doctype")
+
+			operations = operations[:0]
+			So(PromoteBranch(documentCreated.ID, DefaultBranch, "review"), ShouldBeNil)
+			So(operations, ShouldContain, "save document")
+
+			operations = operations[:0]
+			_, _, err = SyncDoctype(&Book{})
+			So(err, ShouldBeNil)
+			So(operations, ShouldContain, "save doctype")
+
+			webhook := &Webhook{DoctypeCode: "intercepted", URL: "http://localhost/hook"}
+			So(webhook.Save(), ShouldBeNil)
+			So(operations, ShouldContain, "save webhook")
+			So(webhook.Delete(), ShouldNotBeNil)
+			So(operations, ShouldContain, "delete webhook")
+			So(webhook.delete(), ShouldBeNil)
+		})
+
+		Convey("Interceptors can abort operations", func() {
+			So(documentCreated.Delete(), ShouldNotBeNil)
+
+			_, err := LoadDocumentByID(documentCreated.ID)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Removed interceptors don't wrap operations anymore", func() {
+			remove()
+			So(documentCreated.Delete(), ShouldBeNil)
+
+			// removing them again does nothing
+			remove()
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package datastore
+
+import (
+	"fmt"
+	"time"
+)
+
+// SaveLockTimeout is how long a save waits for others of the same
+// document to finish, and how long it may hold the document.
+var SaveLockTimeout = 10 * time.Second
+
+// releaseScript deletes the lock only if it's still held with the token.
+const releaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// lock waits to take the lock on the key, held for up to timeout so
+// it's released even if the process dies holding it.
+// Returns the function releasing it.
+func lock(key string, timeout time.Duration) (func(), error) {
+	token := GenerateID(8)
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := Conn.SetNX(key, token, timeout).Result()
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timeout waiting for lock %s", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return func() {
+		Conn.Eval(releaseScript, []string{key}, []string{token})
+	}, nil
+}
+
+// lockDocument waits to take a lock on the document, so saves of it
+// don't interleave.
+func lockDocument(id string) (func(), error) {
+	return lock(joinKey([]string{id, "lock"}), SaveLockTimeout)
+}
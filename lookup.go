@@ -0,0 +1,178 @@
+package datastore
+
+import (
+	"fmt"
+	"gopkg.in/redis.v3"
+	"strings"
+)
+
+// NotFoundError is returned when the objects looked up aren't on the
+// database.
+type NotFoundError struct {
+	// Type of the objects, like doctype or document
+	ObjectType string
+
+	// What they were looked up by, like ID, code or slug
+	By string
+
+	// Keys not found
+	Keys []string
+}
+
+// Error implements error
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s NotFound for %s: %s", strings.Title(e.ObjectType), e.By, strings.Join(e.Keys, ", "))
+}
+
+// IsNotFound tells if the error is a *NotFoundError.
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// Exists tells if there's a document with the ID on the database.
+func Exists(id string) (bool, error) {
+	objectType, err := Conn.HGet(id, "type").Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+
+	return objectType == "document", err
+}
+
+// LoadDocuments loads many documents from the database by ID, reading
+// them together instead of one by one.
+//
+// Documents are returned on the same order of the IDs. The ones not
+// found are left nil and a *NotFoundError listing them is returned.
+func LoadDocuments(ids ...string) ([]*Document, error) {
+	var documents []*Document
+
+	op := &Operation{Kind: OpQuery, ObjectType: "document"}
+	err := intercept(op, func() (err error) {
+		documents, err = loadDocuments(ids)
+		op.Object = documents
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+
+		for _, d := range documents {
+			if d == nil {
+				continue
+			}
+
+			migrated, migrateErr := d.migrate(true)
+			if migrateErr != nil {
+				return migrateErr
+			}
+			if !migrated {
+				continue
+			}
+
+			// see LoadDocumentByID
+			migrateErr = d.saveMigrated()
+			if migrateErr != nil && migrateErr != errDocumentChanged {
+				return migrateErr
+			}
+		}
+
+		return err
+	})
+
+	if result, ok := op.Object.([]*Document); ok {
+		documents = result
+	}
+
+	return documents, err
+}
+
+func loadDocuments(ids []string) ([]*Document, error) {
+	documents := make([]*Document, len(ids))
+
+	// first read the base hashes, to know the doctypes and revisions
+	heads := make([]*redis.StringStringMapCmd, len(ids))
+
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	for i, id := range ids {
+		heads[i] = pipeline.HGetAllMap(id)
+	}
+
+	_, err := pipeline.Exec()
+	if err != nil {
+		return documents, err
+	}
+
+	// then the revisions and values of all of them
+	missing := []string{}
+	doctypes := make(map[string]*Doctype)
+	revisions := make([]*redis.StringStringMapCmd, len(ids))
+	setters := make([]func() error, len(ids))
+
+	values := Conn.Pipeline()
+	defer values.Close()
+
+	for i, id := range ids {
+		get := heads[i].Val()
+
+		if len(get) == 0 {
+			missing = append(missing, id)
+			continue
+		}
+
+		if get["type"] != "document" {
+			return documents, fmt.Errorf("%s is type '%s', expecting 'document'", id, get["type"])
+		}
+
+		doctype, ok := doctypes[get["doctype"]]
+		if !ok {
+			doctype, err = LoadDoctypeByID(get["doctype"])
+			if err != nil {
+				return documents, err
+			}
+			doctypes[get["doctype"]] = doctype
+		}
+
+		d := &Document{
+			ID:              id,
+			Slug:            get["slug"],
+			Doctype:         doctype,
+			DoctypeCode:     doctype.Code,
+			DoctypeRevision: get["doctype_revision"],
+			Revision:        &Revision{ID: get["revision"]},
+			migration:       get["migration"],
+		}
+		documents[i] = d
+
+		revisions[i] = values.HGetAllMap(get["revision"])
+		setters[i] = d.queueValues(id, values)
+	}
+
+	_, err = values.Exec()
+	if err != nil {
+		return documents, err
+	}
+
+	for i, d := range documents {
+		if d == nil {
+			continue
+		}
+
+		err = d.Revision.decode(d.Revision.ID, revisions[i].Val())
+		if err != nil {
+			return documents, err
+		}
+
+		err = setters[i]()
+		if err != nil {
+			return documents, err
+		}
+	}
+
+	if len(missing) > 0 {
+		return documents, &NotFoundError{ObjectType: "document", By: "ID", Keys: missing}
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	Convey("Create a doctype and documents to look up", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "note",
+			VerboseName: "Note",
+			Fields: map[string]*Field{
+				"text": {VerboseName: "Text", ExpectedTypes: []string{"string"}},
+				"tags": {VerboseName: "Tags", ExpectedTypes: []string{"string"}, MultipleValues: true},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		first := &Document{
+			DoctypeCode: "note",
+			Slug:        "first-note",
+			Fields:      map[string]interface{}{"text": "first", "tags": []string{"b", "a"}},
+		}
+		So(first.Save(), ShouldBeNil)
+
+		second := &Document{
+			DoctypeCode: "note",
+			Slug:        "second-note",
+			Fields:      map[string]interface{}{"text": "second", "tags": []string{}},
+		}
+		So(second.Save(), ShouldBeNil)
+
+		Convey("Check documents exist", func() {
+			exists, existsErr := Exists(first.ID)
+			if existsErr != nil {
+				panic(existsErr)
+			}
+			So(exists, ShouldBeTrue)
+
+			exists, existsErr = Exists("missing-document")
+			if existsErr != nil {
+				panic(existsErr)
+			}
+			So(exists, ShouldBeFalse)
+
+			exists, _ = Exists(doctypeCreated.ID)
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("Load documents together", func() {
+			documents, documentsErr := LoadDocuments(second.ID, first.ID)
+			if documentsErr != nil {
+				panic(documentsErr)
+			}
+
+			So(len(documents), ShouldEqual, 2)
+			So(documents[0].ID, ShouldEqual, second.ID)
+			So(documents[0].Fields["text"], ShouldEqual, "second")
+			So(documents[1].Fields["tags"], ShouldResemble, []string{"a", "b"})
+			So(documents[1].Revision.ID, ShouldEqual, first.Revision.ID)
+			So(documents[1].Doctype, ShouldEqual, documents[0].Doctype)
+		})
+
+		Convey("Missing documents are reported", func() {
+			documents, documentsErr := LoadDocuments(first.ID, "missing-document")
+			So(IsNotFound(documentsErr), ShouldBeTrue)
+			So(documentsErr.(*NotFoundError).Keys, ShouldResemble, []string{"missing-document"})
+			So(documents[0].ID, ShouldEqual, first.ID)
+			So(documents[1], ShouldBeNil)
+
+			_, loadErr := LoadDocumentByID("missing-document")
+			So(IsNotFound(loadErr), ShouldBeTrue)
+
+			_, slugErr := LoadDocumentBySlug("note", "missing-note")
+			So(IsNotFound(slugErr), ShouldBeTrue)
+
+			_, doctypeErr := LoadDoctypeByCode("missing-doctype")
+			So(IsNotFound(doctypeErr), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergeConflict describes a field changed on both sides of a merge.
+type MergeConflict struct {
+	// Field's code
+	Field string `json:"field"`
+
+	// Values on the common ancestor and on both sides of the merge
+	Base   interface{} `json:"base"`
+	Ours   interface{} `json:"ours"`
+	Theirs interface{} `json:"theirs"`
+}
+
+// MergeError is returned when a merge couldn't be done automatically.
+type MergeError struct {
+	Conflicts []MergeConflict
+}
+
+// Error implements error
+func (e *MergeError) Error() string {
+	fields := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		fields = append(fields, conflict.Field)
+	}
+	return fmt.Sprintf("Merge conflict on fields: %s", strings.Join(fields, ", "))
+}
+
+// CommonAncestor finds the closest revision both revisions descend from.
+func CommonAncestor(a string, b string) (string, error) {
+	ancestors, err := revisionAncestors(a)
+	if err != nil {
+		return "", err
+	}
+
+	queue := []string{b}
+	visited := map[string]bool{}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if ancestors[id] {
+			return id, nil
+		}
+
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		r, err := LoadRevisionByID(id)
+		if err != nil {
+			return "", err
+		}
+		queue = append(queue, r.parents()...)
+	}
+
+	return "", fmt.Errorf("Revisions %s and %s have no common ancestor", a, b)
+}
+
+// revisionAncestors returns the set of revisions reachable from id,
+// including itself.
+func revisionAncestors(id string) (map[string]bool, error) {
+	ancestors := map[string]bool{}
+	queue := []string{id}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if ancestors[id] {
+			continue
+		}
+		ancestors[id] = true
+
+		r, err := LoadRevisionByID(id)
+		if err != nil {
+			return ancestors, err
+		}
+		queue = append(queue, r.parents()...)
+	}
+
+	return ancestors, nil
+}
+
+// Merge the changes of theirs into the document, field by field,
+// against the revision both have in common.
+//
+// Fields changed on only one side and multiple values fields are merged
+// automatically. If there are conflicts nothing is saved and a
+// *MergeError is returned listing them. Otherwise the document is saved
+// with a merge revision having both revisions as parents.
+func (d *Document) Merge(theirs *Document) error {
+	ancestorID, err := CommonAncestor(d.Revision.ID, theirs.Revision.ID)
+	if err != nil {
+		return err
+	}
+
+	// their changes are already part of ours
+	if ancestorID == theirs.Revision.ID {
+		return nil
+	}
+
+	base, err := LoadDocumentRevision(d.ID, ancestorID)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]interface{})
+	conflicts := []MergeConflict{}
+
+	for _, field := range d.Doctype.Fields {
+		value, ok := mergeValue(field, base.Fields[field.Code], d.Fields[field.Code], theirs.Fields[field.Code])
+		if !ok {
+			conflicts = append(conflicts, MergeConflict{
+				Field:  field.Code,
+				Base:   base.Fields[field.Code],
+				Ours:   d.Fields[field.Code],
+				Theirs: theirs.Fields[field.Code],
+			})
+			continue
+		}
+		merged[field.Code] = value
+	}
+
+	// values unknown to the doctype are kept as ours
+	for code, value := range d.Fields {
+		if _, known := d.Doctype.Fields[code]; !known {
+			merged[code] = value
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Sort(byField(conflicts))
+		return &MergeError{Conflicts: conflicts}
+	}
+
+	d.Fields = merged
+	d.mergeParent = theirs.Revision
+
+	return d.Save()
+}
+
+// MergeBranches merges the head of the branch `from` into the branch `into`.
+func MergeBranches(id string, into string, from string) (*Document, error) {
+	ours, err := LoadDocumentBranch(id, into)
+	if err != nil {
+		return ours, err
+	}
+
+	theirs, err := LoadDocumentBranch(id, from)
+	if err != nil {
+		return ours, err
+	}
+
+	return ours, ours.Merge(theirs)
+}
+
+// mergeValue merges a single field's value.
+// Returns false when both sides changed it differently.
+func mergeValue(f *Field, base, ours, theirs interface{}) (interface{}, bool) {
+	if f.inSet() {
+		return f.mergeValues(base, ours, theirs), true
+	}
+
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours, true
+	case reflect.DeepEqual(base, ours):
+		return theirs, true
+	case reflect.DeepEqual(base, theirs):
+		return ours, true
+	}
+
+	return nil, false
+}
+
+// mergeValues merges multiple values as sets: anything added on
+// either side is added and anything removed on either side is removed.
+//
+// Values are compared as they're stored but kept with their types,
+// ordered as they're loaded.
+func (f *Field) mergeValues(base, ours, theirs interface{}) interface{} {
+	inBase := f.valueSet(base)
+	inTheirs := f.valueSet(theirs)
+	result := f.valueSet(ours)
+
+	for encoded, value := range inTheirs {
+		if _, ok := inBase[encoded]; !ok {
+			result[encoded] = value
+		}
+	}
+
+	for encoded := range inBase {
+		if _, ok := inTheirs[encoded]; !ok {
+			delete(result, encoded)
+		}
+	}
+
+	keys := make([]string, 0, len(result))
+	for encoded := range result {
+		keys = append(keys, encoded)
+	}
+	sort.Strings(keys)
+
+	if f.onlyStrings() {
+		return keys
+	}
+
+	values := make([]interface{}, 0, len(keys))
+	for _, encoded := range keys {
+		values = append(values, result[encoded])
+	}
+
+	return values
+}
+
+// valueSet of a multiple values field's value, keyed by how each
+// value is stored.
+func (f *Field) valueSet(value interface{}) map[string]interface{} {
+	values := toSlice(value)
+
+	set := make(map[string]interface{}, len(values))
+	for _, v := range values {
+		set[f.encodeValue(v)] = v
+	}
+	return set
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+type byField []MergeConflict
+
+func (c byField) Len() int           { return len(c) }
+func (c byField) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byField) Less(i, j int) bool { return c[i].Field < c[j].Field }
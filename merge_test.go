@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	Convey("Create a document with two diverged branches", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "post",
+			"verbose_name": "Post",
+			"fields": {
+				"title": {
+					"verbose_name": "Title",
+					"expected_types": ["string"]
+				},
+				"body": {
+					"verbose_name": "Body",
+					"expected_types": ["string"]
+				},
+				"tags": {
+					"verbose_name": "Tags",
+					"expected_types": ["string"],
+					"multiple_values": true
+				},
+				"ratings": {
+					"verbose_name": "Ratings",
+					"expected_types": ["int"],
+					"multiple_values": true
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+
+		doctypeCreated.Save()
+
+		documentCreated := &Document{
+			Slug:        "merged-post",
+			DoctypeCode: "post",
+			Fields: map[string]interface{}{
+				"title":   "Title",
+				"body":    "Body",
+				"tags":    []string{"go", "redis"},
+				"ratings": []interface{}{3, 4},
+			},
+		}
+		documentCreated.Save()
+
+		draft, draftErr := LoadDocumentByID(documentCreated.ID)
+		if draftErr != nil {
+			panic(draftErr)
+		}
+		draft.Branch = "draft"
+
+		master, masterErr := LoadDocumentByID(documentCreated.ID)
+		if masterErr != nil {
+			panic(masterErr)
+		}
+
+		Convey("Merge changes on different fields", func() {
+			draft.Fields["body"] = "New body"
+			draft.Fields["tags"] = []string{"go", "redis", "merge"}
+			draft.Save()
+
+			master.Fields["title"] = "New title"
+			master.Fields["tags"] = []string{"go"}
+			master.Save()
+
+			merged, mergeErr := MergeBranches(documentCreated.ID, DefaultBranch, "draft")
+			if mergeErr != nil {
+				panic(mergeErr)
+			}
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+
+			So(documentLoaded.Fields["title"], ShouldEqual, "New title")
+			So(documentLoaded.Fields["body"], ShouldEqual, "New body")
+			So(documentLoaded.Fields["tags"], ShouldResemble, []string{"go", "merge"})
+			So(documentLoaded.Revision.Type, ShouldEqual, "merge")
+			So(documentLoaded.Revision.ID, ShouldEqual, merged.Revision.ID)
+			So(documentLoaded.Revision.Parent, ShouldEqual, master.Revision.ID)
+			So(documentLoaded.Revision.MergeParent, ShouldEqual, draft.Revision.ID)
+		})
+
+		Convey("Merge multiple values keeping their types", func() {
+			draft.Fields["ratings"] = []interface{}{3, 4, 5}
+			draft.Save()
+
+			master.Fields["body"] = "New body"
+			master.Save()
+
+			_, mergeErr := MergeBranches(documentCreated.ID, DefaultBranch, "draft")
+			if mergeErr != nil {
+				panic(mergeErr)
+			}
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+
+			So(documentLoaded.Fields["body"], ShouldEqual, "New body")
+			So(documentLoaded.Fields["ratings"], ShouldResemble, []interface{}{3.0, 4.0, 5.0})
+		})
+
+		Convey("Report conflicting changes", func() {
+			draft.Fields["title"] = "Draft title"
+			draft.Save()
+
+			master.Fields["title"] = "Master title"
+			master.Save()
+
+			_, mergeErr := MergeBranches(documentCreated.ID, DefaultBranch, "draft")
+			So(mergeErr, ShouldHaveSameTypeAs, &MergeError{})
+			So(mergeErr.(*MergeError).Conflicts, ShouldResemble, []MergeConflict{{
+				Field:  "title",
+				Base:   "Title",
+				Ours:   "Master title",
+				Theirs: "Draft title",
+			}})
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package datastore
+
+import (
+	"fmt"
+	"gopkg.in/redis.v3"
+	"sync"
+)
+
+// MigrateFunc transforms a document written against an older
+// definition of it's doctype, like converting the values of a field
+// whose ExpectedTypes changed.
+type MigrateFunc func(d *Document) error
+
+// Migration of the documents of a doctype.
+type Migration struct {
+	// Name identifying the migration, recorded on the documents it's
+	// run on.
+	Name string
+
+	// Lazy migrations run whenever a document not migrated yet is
+	// loaded. Otherwise they only run with Migrate.
+	Lazy bool
+
+	Migrate MigrateFunc
+}
+
+// Migrations registered by doctype's code, on the order they run.
+var migrations = map[string][]*Migration{}
+var migrationsMutex sync.RWMutex
+
+// RegisterMigration registers a migration to the documents of the
+// doctype. Migrations run on the order they're registered and should
+// be registered before the datastore is used.
+func RegisterMigration(doctypeCode string, m *Migration) {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+
+	for _, registered := range migrations[doctypeCode] {
+		if registered.Name == m.Name {
+			panic(fmt.Sprintf("Migration '%s' already registered for %s", m.Name, doctypeCode))
+		}
+	}
+
+	migrations[doctypeCode] = append(migrations[doctypeCode], m)
+}
+
+// UnregisterMigrations removes the migrations registered for the
+// doctype, mostly so tests can register them again.
+func UnregisterMigrations(doctypeCode string) {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+
+	delete(migrations, doctypeCode)
+}
+
+// registeredMigrations returns the migrations of the doctype.
+func registeredMigrations(doctypeCode string) []*Migration {
+	migrationsMutex.RLock()
+	defer migrationsMutex.RUnlock()
+
+	return migrations[doctypeCode]
+}
+
+// latestMigration returns the name of the last migration registered
+// for the doctype.
+func latestMigration(doctypeCode string) string {
+	registered := registeredMigrations(doctypeCode)
+	if len(registered) == 0 {
+		return ""
+	}
+	return registered[len(registered)-1].Name
+}
+
+// pendingMigrations returns the migrations not run on the document yet.
+//
+// Documents migrated by a migration not registered here, like by a
+// newer version of the application, have nothing pending, so every
+// process can still read them.
+func (d *Document) pendingMigrations() []*Migration {
+	registered := registeredMigrations(d.Doctype.Code)
+	if len(d.migration) == 0 {
+		return registered
+	}
+
+	for i, m := range registered {
+		if m.Name == d.migration {
+			return registered[i+1:]
+		}
+	}
+
+	return nil
+}
+
+// migrate runs the pending migrations on the document. When lazy is
+// set it stops on the first migration that isn't lazy.
+//
+// The document is only changed in memory, saveMigrated persists it.
+// Returns if any migration was run.
+func (d *Document) migrate(lazy bool) (bool, error) {
+	for _, m := range d.pendingMigrations() {
+		if lazy && !m.Lazy {
+			break
+		}
+
+		err := m.Migrate(d)
+		if err != nil {
+			return false, fmt.Errorf("Migration '%s' failed on document %s: %s", m.Name, d.ID, err)
+		}
+
+		d.migration = m.Name
+		d.migrated = append(d.migrated, m.Name)
+	}
+
+	return len(d.migrated) > 0, nil
+}
+
+// saveMigrated saves the migrations run on the document with a
+// migration revision, unless the document was changed since it was
+// loaded. Then errDocumentChanged is returned and the document is left
+// as it was, so the migrations are saved with it's next save.
+func (d *Document) saveMigrated() error {
+	if len(d.migrated) == 0 {
+		return nil
+	}
+
+	// saved on a copy, a failed save would leave the document half
+	// way, with the values computed and a revision never written.
+	saved := *d
+	saved.Fields = make(map[string]interface{}, len(d.Fields))
+	for code, value := range d.Fields {
+		saved.Fields[code] = value
+	}
+	saved.expectedHead = d.Revision.ID
+
+	err := saved.Save()
+	if err != nil {
+		return err
+	}
+
+	saved.expectedHead = ""
+	*d = saved
+
+	return nil
+}
+
+// migrateDocument runs the pending migrations on the document and
+// saves it. Documents changed meanwhile are loaded and migrated again.
+// Returns if any migration was run.
+func migrateDocument(d *Document) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		ok, err := d.migrate(false)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		err = d.saveMigrated()
+		if err != errDocumentChanged || attempt == 3 {
+			return err == nil, err
+		}
+
+		d, err = loadDocumentByID(d.ID)
+		if IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Migrate runs the pending migrations on all the documents of the
+// doctype, loading batchSize documents at a time.
+// Returns how many documents were migrated.
+func Migrate(doctypeCode string, batchSize int) (int, error) {
+	migrated := 0
+
+	doctype, err := LoadDoctypeByCode(doctypeCode)
+	if err != nil {
+		return migrated, err
+	}
+
+	op := &Operation{Kind: OpMigrate, ObjectType: "doctype", ID: doctype.ID, Object: doctype}
+	err = intercept(op, func() (err error) {
+		migrated, err = migrateAll(doctypeCode, batchSize)
+		return err
+	})
+
+	return migrated, err
+}
+
+func migrateAll(doctypeCode string, batchSize int) (int, error) {
+	migrated := 0
+
+	ids, err := DocumentIDs(doctypeCode, false)
+	if err != nil {
+		return migrated, err
+	}
+
+	if batchSize < 1 {
+		batchSize = len(ids)
+	}
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		documents, err := loadDocuments(ids[start:end])
+		if err != nil && !IsNotFound(err) {
+			return migrated, err
+		}
+
+		for _, d := range documents {
+			if d == nil {
+				continue
+			}
+
+			ok, err := migrateDocument(d)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+	}
+
+	return migrated, nil
+}
+
+// OutdatedDocuments lists the IDs of the documents of the doctype last
+// saved against an older revision of it.
+func OutdatedDocuments(doctypeCode string) ([]string, error) {
+	outdated := []string{}
+
+	doctype, err := LoadDoctypeByCode(doctypeCode)
+	if err != nil {
+		return outdated, err
+	}
+
+	ids, err := DocumentIDs(doctypeCode, false)
+	if err != nil {
+		return outdated, err
+	}
+
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	pinned := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		pinned[i] = pipeline.HGet(id, "doctype_revision")
+	}
+
+	_, err = pipeline.Exec()
+	if err != nil && err != redis.Nil {
+		return outdated, err
+	}
+
+	for i, id := range ids {
+		if pinned[i].Val() != doctype.Revision.ID {
+			outdated = append(outdated, id)
+		}
+	}
+
+	return outdated, nil
+}
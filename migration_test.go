@@ -0,0 +1,259 @@
+package datastore
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMigration(t *testing.T) {
+	Convey("Create documents with a string field", t, func() {
+		UnregisterMigrations("product")
+
+		doctypeCreated := &Doctype{
+			Code:        "product",
+			VerboseName: "Product",
+			Fields: map[string]*Field{
+				"price": {VerboseName: "Price", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		first := &Document{DoctypeCode: "product", Slug: "first", Fields: map[string]interface{}{"price": "12,50"}}
+		So(first.Save(), ShouldBeNil)
+
+		second := &Document{DoctypeCode: "product", Slug: "second", Fields: map[string]interface{}{"price": "30,00"}}
+		So(second.Save(), ShouldBeNil)
+
+		// the field now expects floats
+		doctypeCreated.Fields["price"].ExpectedTypes = []string{"float"}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		// prices were written with decimal commas
+		toFloat := func(d *Document) error {
+			if str, ok := d.Fields["price"].(string); ok {
+				price, err := strconv.ParseFloat(strings.Replace(str, ",", ".", 1), 64)
+				if err != nil {
+					return err
+				}
+				d.Fields["price"] = price
+			}
+			return nil
+		}
+
+		Convey("Lazy migrations run on load", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Lazy: true, Migrate: toFloat})
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, 12.5)
+			So(documentLoaded.Revision.Type, ShouldEqual, "migration")
+			So(documentLoaded.Revision.Parent, ShouldEqual, first.Revision.ID)
+			So(documentLoaded.Revision.Message, ShouldEqual, "Migrate price-to-float")
+
+			// it's only run once
+			documentLoaded, documentLoadedErr = LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, 12.5)
+			So(documentLoaded.Revision.Type, ShouldEqual, "migration")
+		})
+
+		Convey("Lazy migrations that can't be saved fail the load", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Lazy: true, Migrate: toFloat})
+
+			remove := Use(func(op *Operation, next func() error) error {
+				if op.Kind == OpSave && op.ID == first.ID {
+					return errors.New("Read only")
+				}
+				return next()
+			})
+
+			_, documentLoadedErr := LoadDocumentByID(first.ID)
+			remove()
+			So(documentLoadedErr, ShouldNotBeNil)
+			So(documentLoadedErr.Error(), ShouldEqual, "Read only")
+
+			// the next load saves it
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Revision.Type, ShouldEqual, "migration")
+		})
+
+		Convey("Documents migrated by unknown migrations load as they are", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Lazy: true, Migrate: toFloat})
+
+			third := &Document{DoctypeCode: "product", Slug: "third", Fields: map[string]interface{}{"price": 7.5}}
+			So(third.Save(), ShouldBeNil)
+
+			// like a process that doesn't know the migration
+			UnregisterMigrations("product")
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(third.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, 7.5)
+			So(documentLoaded.Revision.ID, ShouldEqual, third.Revision.ID)
+
+			RegisterMigration("product", &Migration{Name: "other", Lazy: true, Migrate: toFloat})
+			documentLoaded, documentLoadedErr = LoadDocumentByID(third.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.pendingMigrations(), ShouldBeEmpty)
+			So(documentLoaded.Revision.ID, ShouldEqual, third.Revision.ID)
+		})
+
+		Convey("Migrations aren't saved over changes made meanwhile", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Lazy: true, Migrate: toFloat})
+
+			stale, staleErr := loadDocumentByID(first.ID)
+			if staleErr != nil {
+				panic(staleErr)
+			}
+			ok, migrateErr := stale.migrate(true)
+			if migrateErr != nil {
+				panic(migrateErr)
+			}
+			So(ok, ShouldBeTrue)
+
+			// saved by another process, validated by the old definition
+			first.Fields["price"] = "13,00"
+			So(first.Save(), ShouldBeNil)
+
+			So(stale.saveMigrated(), ShouldEqual, errDocumentChanged)
+			So(stale.Revision.ID, ShouldNotEqual, first.Revision.ID)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, 13.0)
+			So(documentLoaded.Revision.Parent, ShouldEqual, first.Revision.ID)
+		})
+
+		Convey("Documents missing from the doctype's index are migrated once backfilled", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Migrate: toFloat})
+
+			// as saved before the doctypes indexed their documents
+			Conn.SRem(joinKey([]string{doctypeCreated.ID, "documents"}), first.ID)
+
+			migrated, migrateErr := Migrate("product", 10)
+			if migrateErr != nil {
+				panic(migrateErr)
+			}
+			So(migrated, ShouldEqual, 1)
+
+			added, backfillErr := BackfillDocumentIndexes()
+			if backfillErr != nil {
+				panic(backfillErr)
+			}
+			So(added, ShouldBeGreaterThanOrEqualTo, 1)
+			So(Conn.SIsMember(joinKey([]string{doctypeCreated.ID, "documents"}), first.ID).Val(), ShouldBeTrue)
+
+			migrated, migrateErr = Migrate("product", 10)
+			if migrateErr != nil {
+				panic(migrateErr)
+			}
+			So(migrated, ShouldEqual, 1)
+		})
+
+		Convey("Eager migrations run in batches", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Migrate: toFloat})
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(first.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, "12,50")
+
+			migrated, migrateErr := Migrate("product", 1)
+			if migrateErr != nil {
+				panic(migrateErr)
+			}
+			So(migrated, ShouldEqual, 2)
+
+			documentLoaded, documentLoadedErr = LoadDocumentByID(second.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["price"], ShouldEqual, 30.0)
+			So(documentLoaded.Revision.Type, ShouldEqual, "migration")
+
+			migrated, _ = Migrate("product", 1)
+			So(migrated, ShouldEqual, 0)
+		})
+
+		Convey("New documents are created migrated", func() {
+			RegisterMigration("product", &Migration{Name: "price-to-float", Migrate: toFloat})
+
+			third := &Document{DoctypeCode: "product", Slug: "third", Fields: map[string]interface{}{"price": 7.5}}
+			So(third.Save(), ShouldBeNil)
+
+			So(third.pendingMigrations(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestOutdatedDocuments(t *testing.T) {
+	Convey("Create a document and change it's doctype", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "event",
+			VerboseName: "Event",
+			Fields: map[string]*Field{
+				"name": {VerboseName: "Name", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+		firstSchema := doctypeCreated.Revision.ID
+
+		documentCreated := &Document{DoctypeCode: "event", Slug: "launch", Fields: map[string]interface{}{"name": "Launch"}}
+		So(documentCreated.Save(), ShouldBeNil)
+		So(documentCreated.DoctypeRevision, ShouldEqual, firstSchema)
+		firstRevision := documentCreated.Revision.ID
+
+		So(doctypeCreated.RenameField("name", "title"), ShouldBeNil)
+
+		Convey("Old revisions load with the doctype of their time", func() {
+			documentLoaded, documentLoadedErr := LoadDocumentRevision(documentCreated.ID, firstRevision)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Doctype.Revision.ID, ShouldEqual, firstSchema)
+			So(documentLoaded.Fields["name"], ShouldEqual, "Launch")
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeRevision(doctypeCreated.ID, firstSchema)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields, ShouldContainKey, "name")
+			So(doctypeLoaded.Fields, ShouldNotContainKey, "title")
+		})
+
+		Convey("List documents on an outdated doctype", func() {
+			outdated, outdatedErr := OutdatedDocuments("event")
+			if outdatedErr != nil {
+				panic(outdatedErr)
+			}
+			So(outdated, ShouldResemble, []string{documentCreated.ID})
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Save(), ShouldBeNil)
+			So(documentLoaded.DoctypeRevision, ShouldEqual, doctypeCreated.Revision.ID)
+
+			outdated, _ = OutdatedDocuments("event")
+			So(outdated, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestNested(t *testing.T) {
+	Convey("Create a doctype with sub-document fields", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "customer",
+			VerboseName: "Customer",
+			Strict:      true,
+			Fields: map[string]*Field{
+				"name": {VerboseName: "Name", ExpectedTypes: []string{"string"}},
+				"address": {
+					VerboseName:   "Address",
+					ExpectedTypes: []string{"object"},
+					Fields: map[string]*Field{
+						"city": {VerboseName: "City", ExpectedTypes: []string{"string"}, Required: true},
+						"zip":  {VerboseName: "Zip", ExpectedTypes: []string{"string"}},
+					},
+				},
+				"phones": {
+					VerboseName:    "Phones",
+					ExpectedTypes:  []string{"object"},
+					MultipleValues: true,
+					Fields: map[string]*Field{
+						"kind":   {VerboseName: "Kind", ExpectedTypes: []string{"string"}, Enum: []interface{}{"home", "work"}},
+						"number": {VerboseName: "Number", ExpectedTypes: []string{"string"}},
+					},
+				},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		Convey("Sub-documents' definitions are loaded", func() {
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields["address"].Fields["city"].Required, ShouldBeTrue)
+			So(doctypeLoaded.Fields["phones"].Fields["kind"].Code, ShouldEqual, "kind")
+		})
+
+		Convey("Sub-documents are validated", func() {
+			documentCreated := &Document{
+				DoctypeCode: "customer",
+				Slug:        "invalid",
+				Fields: map[string]interface{}{
+					"address": map[string]interface{}{"zip": "01000", "country": "BR"},
+					"phones":  []interface{}{map[string]interface{}{"kind": "mobile"}},
+				},
+			}
+
+			err := documentCreated.Save()
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+
+			report := err.(*ValidationError)
+			So(report.Fields, ShouldContainKey, "address.city")
+			So(report.Fields, ShouldContainKey, "address.country")
+			So(report.Fields, ShouldContainKey, "phones.0.kind")
+		})
+
+		Convey("Sub-documents are stored and versioned with the document", func() {
+			documentCreated := &Document{
+				DoctypeCode: "customer",
+				Slug:        "ada",
+				Fields: map[string]interface{}{
+					"name":    "Ada",
+					"address": map[string]interface{}{"city": "London"},
+					"phones": []interface{}{
+						map[string]interface{}{"kind": "work", "number": "2"},
+						map[string]interface{}{"kind": "home", "number": "1"},
+					},
+				},
+			}
+			So(documentCreated.Save(), ShouldBeNil)
+			firstRevision := documentCreated.Revision.ID
+
+			documentCreated.Fields["address"] = map[string]interface{}{"city": "Paris"}
+			So(documentCreated.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["address"], ShouldResemble, map[string]interface{}{"city": "Paris"})
+			So(documentLoaded.Fields["phones"], ShouldResemble, []interface{}{
+				map[string]interface{}{"kind": "work", "number": "2"},
+				map[string]interface{}{"kind": "home", "number": "1"},
+			})
+
+			documentRevision, documentRevisionErr := LoadDocumentRevision(documentCreated.ID, firstRevision)
+			if documentRevisionErr != nil {
+				panic(documentRevisionErr)
+			}
+			So(documentRevision.Fields["address"], ShouldResemble, map[string]interface{}{"city": "London"})
+		})
+	})
+}
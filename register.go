@@ -2,55 +2,58 @@ package datastore
 
 import (
 	"reflect"
-	"strings"
 )
 
-// global function so we can easily access a doctype without needing to retrieve it from the database
-var Doctypes = make(map[string]*Doctype)
-
 // Register a Go Struct as a Doctype
+//
+// Exported fields, including the ones of embedded structs, are named by
+// their json tags and typed after their Go types: slices store multiple
+// values, structs are sub-documents and pointers to other Documenters
+// are references. The datastore tag sets the other options of a field,
+// see parseFieldTag.
+//
+// Registering a doctype again, like on every process start, saves only
+// the compatible changes made to it. See SyncDoctype.
+// The doctypes registered can be retrieved from Doctypes.
 func RegisterDoctype(doctype Documenter) {
-	doctypeType := reflect.TypeOf(doctype)
-	code := doctype.DoctypeCode()
-
-	newDoctype := &Doctype{
-		Code:        code, // could also use sstrings.ToLower(doctypeType.String()) as default value
-		VerboseName: doctypeType.Name(),
-		Fields:      make(map[string]*Field),
+	_, _, err := SyncDoctype(doctype)
+	if err != nil {
+		panic(err)
 	}
+}
+
+// doctypeFromStruct builds the definition of the Documenter's doctype.
+func doctypeFromStruct(doctype Documenter) (*Doctype, error) {
+	doctypeType := reflect.TypeOf(doctype)
 
 	// is it a pointer? if so, get the struct
 	if doctypeType.Kind() == reflect.Ptr {
 		doctypeType = doctypeType.Elem()
 	}
 
-	for i := 0; i < doctypeType.NumField(); i++ {
-		typeField := doctypeType.Field(i)
-		tags := typeField.Tag
-
-		jsonTags := strings.Split(tags.Get("json"), ",")
-		jsonName := jsonTags[0]
+	newDoctype := &Doctype{
+		Code:        doctype.DoctypeCode(), // could also use sstrings.ToLower(doctypeType.String()) as default value
+		VerboseName: doctypeType.Name(),
+		Fields:      make(map[string]*Field),
+	}
 
-		// if name is - then ignore it
-		if jsonName == "-" {
-			continue
+	for _, structField := range structFields(doctypeType) {
+		field, err := fieldFromType(structField.typ.Type)
+		if err != nil {
+			return newDoctype, err
 		}
 
-		// if there's no struct tag defining it's name use the struct's field name as default
-		if jsonName == "" {
-			jsonName = typeField.Name
+		field.Code = structField.code
+		field.VerboseName = structField.typ.Name
+
+		err = parseFieldTag(newDoctype, field, structField.typ.Tag.Get("datastore"))
+		if err != nil {
+			return newDoctype, err
 		}
 
 		// set field to the new doctype we're building
-		newDoctype.Fields[jsonName] = &Field{
-			Code:          jsonName,
-			VerboseName:   typeField.Name,
-			ExpectedTypes: []string{typeField.Type.String()},
-		}
+		newDoctype.Fields[field.Code] = field
 	}
 
-	Doctypes[code] = newDoctype
-
-	// save new doctype to the database
-	newDoctype.Save()
+	return newDoctype, nil
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 type User struct {
@@ -26,15 +27,18 @@ func TestRegisterDocumenter(t *testing.T) {
 		user := &User{}
 		RegisterDoctype(user)
 
-		_, has_user_doctype := Doctypes[user.DoctypeCode()]
+		_, has_user_doctype := Doctypes.Get(user.DoctypeCode())
 		So(has_user_doctype, ShouldBeTrue)
 
 		Convey("Save a document instance to the Database", func() {
-			user.Username = "alisson"
+			user.Username = "alisson-" + GenerateID(4)
 			user.Name = "Alisson Patricio"
 			user.WithoutName = "Alisson Patricio"
 
-			documentCreated := CreateDocument(user)
+			documentCreated, documentCreatedErr := CreateDocument(user)
+			if documentCreatedErr != nil {
+				panic(documentCreatedErr)
+			}
 
 			Convey("Compare document created with loaded", func() {
 				documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
@@ -58,7 +62,10 @@ func TestRegisterDocumenter(t *testing.T) {
 			Convey("Update document", func() {
 				user.Name = "Oicirtap Nossila"
 
-				documentUpdated := UpdateDocument(documentCreated.ID, user)
+				documentUpdated, documentUpdatedErr := UpdateDocument(documentCreated.ID, user)
+				if documentUpdatedErr != nil {
+					panic(documentUpdatedErr)
+				}
 
 				Convey("Compare document updated with loaded", func() {
 					documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
@@ -82,3 +89,209 @@ func TestRegisterDocumenter(t *testing.T) {
 		})
 	})
 }
+
+type Author struct {
+	Name string `json:"name" datastore:"required,verbose=Full name"`
+}
+
+func (a *Author) Slug() string {
+	return Slugify(a.Name)
+}
+
+func (a *Author) DoctypeCode() string {
+	return "author"
+}
+
+type Timestamps struct {
+	Created time.Time `json:"created"`
+}
+
+type Post struct {
+	Timestamps
+	Title  string   `json:"title" datastore:"slug,min_length=2"`
+	Views  int64    `json:"views,omitempty" datastore:"min=0"`
+	Rating float64  `json:"rating"`
+	Tags   []string `json:"tags"`
+	Author *Author  `json:"author"`
+	Status string   `json:"status" datastore:"enum=draft|published,default=draft"`
+	Meta   struct {
+		Description string `json:"description"`
+	} `json:"meta"`
+	secret string
+}
+
+func (p *Post) Slug() string {
+	return Slugify(p.Title)
+}
+
+func (p *Post) DoctypeCode() string {
+	return "blog_post"
+}
+
+func TestRegisterStructTypes(t *testing.T) {
+	Convey("Registering Doctypes with typed fields", t, func() {
+		RegisterDoctype(&Author{})
+		RegisterDoctype(&Post{})
+
+		post, _ := Doctypes.Get("blog_post")
+		author, _ := Doctypes.Get("author")
+
+		Convey("Go types are mapped to fields", func() {
+			So(post.VerboseName, ShouldEqual, "Post")
+			So(post.SlugField, ShouldEqual, "title")
+			So(post.Fields, ShouldNotContainKey, "secret")
+
+			So(post.Fields["created"].ExpectedTypes, ShouldResemble, []string{"string"})
+			So(post.Fields["views"].ExpectedTypes, ShouldResemble, []string{"int"})
+			So(*post.Fields["views"].Min, ShouldEqual, 0.0)
+			So(post.Fields["rating"].ExpectedTypes, ShouldResemble, []string{"float"})
+			So(post.Fields["tags"].ExpectedTypes, ShouldResemble, []string{"string"})
+			So(post.Fields["tags"].MultipleValues, ShouldBeTrue)
+			So(post.Fields["author"].ExpectedTypes, ShouldResemble, []string{"author"})
+			So(post.Fields["status"].Enum, ShouldResemble, []interface{}{"draft", "published"})
+			So(post.Fields["status"].Default, ShouldEqual, "draft")
+			So(post.Fields["meta"].ExpectedTypes, ShouldResemble, []string{"object"})
+			So(post.Fields["meta"].Fields, ShouldContainKey, "description")
+			So(author.Fields["name"].VerboseName, ShouldEqual, "Full name")
+			So(author.Fields["name"].Required, ShouldBeTrue)
+		})
+
+		Convey("References are stored by ID and loaded back", func() {
+			author := &Author{Name: "Ada Lovelace " + GenerateID(4)}
+			authorCreated, authorCreatedErr := CreateDocument(author)
+			if authorCreatedErr != nil {
+				panic(authorCreatedErr)
+			}
+
+			created := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+			postCreated, postCreatedErr := CreateDocument(&Post{
+				Timestamps: Timestamps{Created: created},
+				Title:      "Notes " + GenerateID(4),
+				Views:      5,
+				Rating:     4.5,
+				Tags:       []string{"math", "engines"},
+				Author:     author,
+				Status:     "published",
+			})
+			if postCreatedErr != nil {
+				panic(postCreatedErr)
+			}
+			So(postCreated.Fields["author"], ShouldEqual, authorCreated.ID)
+
+			loaded, loadedErr := Get[*Post](postCreated.ID)
+			if loadedErr != nil {
+				panic(loadedErr)
+			}
+			So(loaded.Created.Equal(created), ShouldBeTrue)
+			So(loaded.Views, ShouldEqual, int64(5))
+			So(loaded.Rating, ShouldEqual, 4.5)
+			So(loaded.Tags, ShouldResemble, []string{"engines", "math"})
+			So(loaded.Author, ShouldResemble, author)
+		})
+	})
+}
+
+type Level int
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"junior", "senior"}[l])
+}
+
+func (l *Level) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if name == "senior" {
+		*l = 1
+	}
+	return err
+}
+
+type Member struct {
+	Name   string  `json:"name"`
+	Key    [4]byte `json:"key"`
+	Level  Level   `json:"level"`
+	Mentor *Member `json:"mentor"`
+}
+
+func (m *Member) Slug() string {
+	return Slugify(m.Name)
+}
+
+func (m *Member) DoctypeCode() string {
+	return "member"
+}
+
+func TestRegisterStructValues(t *testing.T) {
+	Convey("Register a Documenter referencing it's own doctype", t, func() {
+		RegisterDoctype(&Member{})
+		member, _ := Doctypes.Get("member")
+
+		Convey("Fields are declared as they're encoded", func() {
+			So(member.Fields["key"].ExpectedTypes, ShouldResemble, []string{"int"})
+			So(member.Fields["key"].MultipleValues, ShouldBeTrue)
+			So(member.Fields["level"].ExpectedTypes, ShouldResemble, []string{"string"})
+		})
+
+		Convey("Cyclic references are stored by ID", func() {
+			first := &Member{Name: "First " + GenerateID(4), Key: [4]byte{1, 2, 3, 4}, Level: 1}
+			firstCreated, firstCreatedErr := CreateDocument(first)
+			if firstCreatedErr != nil {
+				panic(firstCreatedErr)
+			}
+
+			second := &Member{Name: "Second " + GenerateID(4), Mentor: first}
+			secondCreated, secondCreatedErr := CreateDocument(second)
+			if secondCreatedErr != nil {
+				panic(secondCreatedErr)
+			}
+
+			first.Mentor = second
+			firstUpdated, firstUpdatedErr := UpdateDocument(firstCreated.ID, first)
+			So(firstUpdatedErr, ShouldBeNil)
+			So(firstUpdated.Fields["mentor"], ShouldEqual, secondCreated.ID)
+			So(firstUpdated.Fields["level"], ShouldEqual, "senior")
+
+			loaded, loadedErr := Get[*Member](firstCreated.ID)
+			if loadedErr != nil {
+				panic(loadedErr)
+			}
+			So(loaded.Level, ShouldEqual, Level(1))
+			So(loaded.Mentor.Name, ShouldEqual, second.Name)
+			So(loaded.Mentor.Mentor, ShouldEqual, loaded)
+		})
+	})
+}
+
+func TestFieldTags(t *testing.T) {
+	Convey("Parse datastore tags", t, func() {
+		Convey("Quoted values hold commas and quotes", func() {
+			f := &Field{Code: "code"}
+			tagErr := parseFieldTag(nil, f, `pattern='^[a-z]{2,8}$',verbose='Author''s code, short',default='[1,2]',required`)
+			So(tagErr, ShouldBeNil)
+			So(f.Pattern, ShouldEqual, "^[a-z]{2,8}$")
+			So(f.VerboseName, ShouldEqual, "Author's code, short")
+			So(f.Default, ShouldResemble, []interface{}{1.0, 2.0})
+			So(f.Required, ShouldBeTrue)
+		})
+
+		Convey("Unquoted values are taken as they are", func() {
+			f := &Field{Code: "name"}
+			So(parseFieldTag(nil, f, "verbose=Author's name,max_length=20"), ShouldBeNil)
+			So(f.VerboseName, ShouldEqual, "Author's name")
+			So(*f.MaxLength, ShouldEqual, 20)
+		})
+
+		Convey("Invalid numbers are refused without being set", func() {
+			f := &Field{Code: "count"}
+			So(parseFieldTag(nil, f, "min=abc"), ShouldNotBeNil)
+			So(f.Min, ShouldBeNil)
+			So(parseFieldTag(nil, f, "max_length=x"), ShouldNotBeNil)
+			So(f.MaxLength, ShouldBeNil)
+		})
+
+		Convey("Unterminated quotes are refused", func() {
+			So(parseFieldTag(nil, &Field{Code: "name"}, "verbose='Name,required"), ShouldNotBeNil)
+			So(parseFieldTag(nil, &Field{Code: "name"}, "verbose='Name'x"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,260 @@
+package datastore
+
+import (
+	"sync"
+)
+
+// DoctypeRegistry caches the doctypes' current definitions by code and
+// ID, so documents don't load them from the database on every save or
+// load. It's safe for concurrent use.
+//
+// Everything cached is dropped whenever a doctype is saved, by this or
+// any other process, as it's doctype events are received. Doctypes
+// inherit their parents' fields, so a change to one may change others.
+// While the events can't be received nothing is cached.
+type DoctypeRegistry struct {
+	mutex sync.RWMutex
+
+	// Doctypes by ID, and their IDs by code
+	doctypes map[string]*Doctype
+	ids      map[string]string
+
+	// Incremented whenever the registry is invalidated, so definitions
+	// loaded before aren't cached after it.
+	generation uint64
+
+	subscription *Subscription
+}
+
+// Doctypes registered or loaded, by code and ID.
+var Doctypes = &DoctypeRegistry{}
+
+// Get the current definition of the doctype by code.
+// ok is false when it couldn't be loaded.
+func (r *DoctypeRegistry) Get(code string) (d *Doctype, ok bool) {
+	d, err := LoadDoctypeByCode(code)
+	return d, err == nil
+}
+
+// Invalidate drops all the definitions cached.
+func (r *DoctypeRegistry) Invalidate() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.invalidate()
+}
+
+func (r *DoctypeRegistry) invalidate() {
+	r.doctypes = nil
+	r.ids = nil
+	r.generation++
+}
+
+// byID returns a copy of the doctype's cached definition.
+func (r *DoctypeRegistry) byID(id string) (*Doctype, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	d, ok := r.doctypes[id]
+	if !ok {
+		return nil, false
+	}
+	return d.clone(), true
+}
+
+// idOf returns the ID of the doctype cached by code.
+func (r *DoctypeRegistry) idOf(code string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	id, ok := r.ids[code]
+	return id, ok
+}
+
+// load the doctype's definition by ID, from the cache when it's there.
+func (r *DoctypeRegistry) load(id string) (*Doctype, error) {
+	if d, ok := r.byID(id); ok {
+		return d, nil
+	}
+
+	generation, cache := r.current()
+
+	d, err := loadDoctypeByID(id)
+	if err == nil && cache {
+		r.store(d, generation)
+	}
+
+	return d, err
+}
+
+// current returns the registry's generation, to be given to store
+// along the definitions loaded after it. ok is false when the doctype
+// events can't be subscribed to, and nothing should be cached.
+//
+// Subscribing waits on the server, so it's done without holding the
+// registry, and the subscription of whoever installs one first is kept.
+func (r *DoctypeRegistry) current() (generation uint64, ok bool) {
+	r.mutex.RLock()
+	generation, subscribed := r.generation, r.subscription != nil
+	r.mutex.RUnlock()
+
+	if subscribed {
+		return generation, true
+	}
+
+	subscription, err := Subscribe(EventFilter{ObjectType: "doctype"})
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.subscription != nil {
+		if err == nil {
+			subscription.Close()
+		}
+		return r.generation, true
+	}
+
+	if err != nil {
+		return r.generation, false
+	}
+	r.subscription = subscription
+
+	go r.listen(subscription)
+
+	return r.generation, true
+}
+
+// store a copy of the doctype's definition, unless the registry was
+// invalidated since the generation it was loaded on.
+func (r *DoctypeRegistry) store(d *Doctype, generation uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if generation != r.generation {
+		return
+	}
+
+	if r.doctypes == nil {
+		r.doctypes = make(map[string]*Doctype)
+		r.ids = make(map[string]string)
+	}
+	r.doctypes[d.ID] = d.clone()
+	r.ids[d.Code] = d.ID
+}
+
+// listen to the doctype events, invalidating the registry on each one.
+// Once the subscription ends changes could be missed, so everything is
+// dropped and it's subscribed to again on the next load.
+func (r *DoctypeRegistry) listen(s *Subscription) {
+	for range s.Events {
+		r.Invalidate()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.subscription == s {
+		r.subscription = nil
+	}
+	r.invalidate()
+}
+
+// clone the doctype's definition, so the copy can be changed without
+// changing the original.
+func (d *Doctype) clone() *Doctype {
+	copied := *d
+
+	copied.Extends = append(d.Extends[:0:0], d.Extends...)
+	copied.UniqueTogether = append(d.UniqueTogether[:0:0], d.UniqueTogether...)
+	for i, group := range copied.UniqueTogether {
+		copied.UniqueTogether[i] = append(group[:0:0], group...)
+	}
+	copied.Fields = cloneFields(d.Fields)
+	copied.Revision = cloneRevision(d.Revision)
+
+	if d.inherited != nil {
+		copied.inherited = make(map[string]string, len(d.inherited))
+		for code, revisionID := range d.inherited {
+			copied.inherited[code] = revisionID
+		}
+	}
+
+	return &copied
+}
+
+func cloneFields(fields map[string]*Field) map[string]*Field {
+	if fields == nil {
+		return nil
+	}
+
+	copied := make(map[string]*Field, len(fields))
+	for code, field := range fields {
+		f := *field
+		f.ExpectedTypes = append(field.ExpectedTypes[:0:0], field.ExpectedTypes...)
+		f.Min = cloneFloat(field.Min)
+		f.Max = cloneFloat(field.Max)
+		f.MinLength = cloneInt(field.MinLength)
+		f.MaxLength = cloneInt(field.MaxLength)
+		f.MinItems = cloneInt(field.MinItems)
+		f.MaxItems = cloneInt(field.MaxItems)
+		f.Default = cloneValue(field.Default)
+		f.Revision = cloneRevision(field.Revision)
+		f.Fields = cloneFields(field.Fields)
+
+		if field.Enum != nil {
+			f.Enum = make([]interface{}, len(field.Enum))
+			for i, value := range field.Enum {
+				f.Enum[i] = cloneValue(value)
+			}
+		}
+
+		copied[code] = &f
+	}
+	return copied
+}
+
+func cloneRevision(r *Revision) *Revision {
+	if r == nil {
+		return nil
+	}
+	copied := *r
+	return &copied
+}
+
+func cloneFloat(n *float64) *float64 {
+	if n == nil {
+		return nil
+	}
+	copied := *n
+	return &copied
+}
+
+func cloneInt(n *int) *int {
+	if n == nil {
+		return nil
+	}
+	copied := *n
+	return &copied
+}
+
+// cloneValue copies the lists and objects of a value decoded from JSON,
+// other values are immutable.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = cloneValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = cloneValue(item)
+		}
+		return copied
+	case []string:
+		return append(v[:0:0], v...)
+	}
+	return value
+}
@@ -0,0 +1,207 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoctypeRegistry(t *testing.T) {
+	Convey("Create a doctype and load it", t, func() {
+		code := "planet_" + GenerateID(4)
+		doctypeCreated := &Doctype{
+			Code:        code,
+			VerboseName: "Planet",
+			SlugField:   "name",
+			Fields: map[string]*Field{
+				"name": {VerboseName: "Name", ExpectedTypes: []string{"string"}},
+			},
+		}
+		// subscribed before the save, so it's event is received
+		_, subscribed := Doctypes.current()
+		So(subscribed, ShouldBeTrue)
+
+		So(doctypeCreated.Save(), ShouldBeNil)
+		waitForEvents()
+
+		doctypeLoaded, doctypeLoadedErr := LoadDoctypeByCode(code)
+		if doctypeLoadedErr != nil {
+			panic(doctypeLoadedErr)
+		}
+
+		Convey("Loads are served from the registry", func() {
+			// changed behind the registry's back, without an event
+			Conn.HSet(doctypeCreated.ID, "verbose_name", "Dwarf planet")
+
+			cached, cachedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if cachedErr != nil {
+				panic(cachedErr)
+			}
+			So(cached.VerboseName, ShouldEqual, "Planet")
+
+			registered, ok := Doctypes.Get(code)
+			So(ok, ShouldBeTrue)
+			So(registered.VerboseName, ShouldEqual, "Planet")
+
+			Doctypes.Invalidate()
+			reloaded, reloadedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if reloadedErr != nil {
+				panic(reloadedErr)
+			}
+			So(reloaded.VerboseName, ShouldEqual, "Dwarf planet")
+		})
+
+		Convey("Copies are loaded, changing them doesn't change the registry", func() {
+			doctypeLoaded.VerboseName = "Moon"
+			doctypeLoaded.Fields["name"].Required = true
+
+			again, againErr := LoadDoctypeByCode(code)
+			if againErr != nil {
+				panic(againErr)
+			}
+			So(again.VerboseName, ShouldEqual, "Planet")
+			So(again.Fields["name"].Required, ShouldBeFalse)
+		})
+
+		Convey("Saving the doctype invalidates the registry", func() {
+			So(doctypeLoaded.AddField("moons", &Field{VerboseName: "Moons", ExpectedTypes: []string{"int"}}), ShouldBeNil)
+
+			again, againErr := LoadDoctypeByCode(code)
+			if againErr != nil {
+				panic(againErr)
+			}
+			So(again.Fields, ShouldContainKey, "moons")
+			So(again.Revision.ID, ShouldEqual, doctypeLoaded.Revision.ID)
+		})
+
+		Convey("Changes saved by other processes invalidate the registry", func() {
+			// another process saves a change and publishes it's event
+			Conn.HSet(doctypeCreated.ID, "verbose_name", "Exoplanet")
+			publish(Event{ObjectID: doctypeCreated.ID, ObjectType: "doctype", DoctypeCode: code, ChangeType: "update"})
+
+			verboseName := ""
+			for i := 0; i < 100 && verboseName != "Exoplanet"; i++ {
+				again, againErr := LoadDoctypeByCode(code)
+				if againErr != nil {
+					panic(againErr)
+				}
+				verboseName = again.VerboseName
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(verboseName, ShouldEqual, "Exoplanet")
+		})
+
+		Convey("Doctypes are loaded and saved concurrently", func() {
+			wg := sync.WaitGroup{}
+			errs := make(chan error, 40)
+
+			for i := 0; i < 20; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_, err := LoadDoctypeByCode(code)
+					errs <- err
+				}()
+				go func() {
+					defer wg.Done()
+					errs <- (&Document{DoctypeCode: code, Fields: map[string]interface{}{"name": "Kepler-" + GenerateID(4)}}).Save()
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+}
+
+func TestRegistrySubscription(t *testing.T) {
+	Convey("Loads racing on a new registry keep a single subscription", t, func() {
+		registry := &DoctypeRegistry{}
+
+		wg := sync.WaitGroup{}
+		subscribed := make(chan bool, 10)
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, ok := registry.current()
+				subscribed <- ok
+			}()
+		}
+		wg.Wait()
+		close(subscribed)
+
+		for ok := range subscribed {
+			So(ok, ShouldBeTrue)
+		}
+
+		registry.mutex.RLock()
+		subscription := registry.subscription
+		registry.mutex.RUnlock()
+
+		So(subscription, ShouldNotBeNil)
+		So(subscription.Close(), ShouldBeNil)
+	})
+}
+
+// waitForEvents waits, up to a second, for the registry to receive the
+// event of the last doctype saved and be invalidated by it.
+func waitForEvents() {
+	Doctypes.mutex.RLock()
+	saved := Doctypes.generation
+	Doctypes.mutex.RUnlock()
+
+	for i := 0; i < 100; i++ {
+		Doctypes.mutex.RLock()
+		generation := Doctypes.generation
+		Doctypes.mutex.RUnlock()
+
+		if generation > saved {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloneDoctype(t *testing.T) {
+	Convey("Clone a doctype's definition", t, func() {
+		min, maxLength := 0.0, 10
+		original := &Doctype{
+			Code:     "clone",
+			Revision: &Revision{ID: "revision"},
+			Fields: map[string]*Field{
+				"radius": {
+					ExpectedTypes: []string{"float"},
+					Min:           &min,
+					MaxLength:     &maxLength,
+					Enum:          []interface{}{map[string]interface{}{"unit": "km"}},
+					Default:       []interface{}{"rocky"},
+					Revision:      &Revision{ID: "field-revision"},
+				},
+			},
+		}
+
+		copied := original.clone()
+		field := copied.Fields["radius"]
+
+		*field.Min = 1
+		*field.MaxLength = 20
+		field.Enum[0].(map[string]interface{})["unit"] = "mi"
+		field.Default.([]interface{})[0] = "gas"
+		field.Revision.ID = "changed"
+		copied.Revision.ID = "changed"
+
+		radius := original.Fields["radius"]
+		So(*radius.Min, ShouldEqual, 0.0)
+		So(*radius.MaxLength, ShouldEqual, 10)
+		So(radius.Enum[0], ShouldResemble, map[string]interface{}{"unit": "km"})
+		So(radius.Default, ShouldResemble, []interface{}{"rocky"})
+		So(radius.Revision.ID, ShouldEqual, "field-revision")
+		So(original.Revision.ID, ShouldEqual, "revision")
+	})
+}
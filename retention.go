@@ -0,0 +1,344 @@
+package datastore
+
+import (
+	"gopkg.in/redis.v3"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy defines which revisions of an object survive Compact.
+//
+// A revision is kept if any of the rules keep it. The current head, or
+// the last revision of objects deleted, and the heads of all branches
+// are always kept.
+type RetentionPolicy struct {
+	// Keep the last N revisions, zero disables the rule.
+	KeepLast int
+
+	// Keep the revisions made within this duration, zero disables the rule.
+	KeepFor time.Duration
+
+	// Keep the revisions with a tag.
+	KeepTagged bool
+}
+
+// TagRevision gives a name to a revision of the object.
+// Tagging a new revision with the same name moves the tag.
+func TagRevision(objectID string, tag string, revisionID string) error {
+	return Conn.HSet(joinKey([]string{objectID, "tags"}), tag, revisionID).Err()
+}
+
+// RevisionTags returns the object's tags mapped to their revisions.
+func RevisionTags(objectID string) (map[string]string, error) {
+	return Conn.HGetAllMap(joinKey([]string{objectID, "tags"})).Result()
+}
+
+// Compact removes the revisions of the object not kept by the policy.
+// Parents of the remaining revisions are rewritten to skip the removed
+// ones, so the chain can still be followed. Documents are locked while
+// compacted, so they aren't saved on top of a revision being removed.
+//
+// Returns how many revisions were removed.
+func Compact(objectID string, policy RetentionPolicy) (int, error) {
+	removed := 0
+
+	objectType, err := objectTypeOf(objectID)
+	if err != nil {
+		return removed, err
+	}
+
+	op := &Operation{Kind: OpCompact, ObjectType: objectType, ID: objectID}
+	err = intercept(op, func() (err error) {
+		if objectType == "document" {
+			unlock, err := lockDocument(objectID)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+
+		removed, err = compact(objectID, objectType, policy)
+		return err
+	})
+
+	return removed, err
+}
+
+// objectTypeOf returns the type of the object, told by it's revisions
+// when it was deleted.
+func objectTypeOf(objectID string) (string, error) {
+	objectType, err := Conn.HGet(objectID, "type").Result()
+	if err != redis.Nil {
+		return objectType, err
+	}
+
+	ids, err := Conn.ZRange(joinKey([]string{objectID, "revisions"}), -1, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+
+	objectType, err = Conn.HGet(ids[0], "object_type").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return objectType, err
+}
+
+func compact(objectID string, objectType string, policy RetentionPolicy) (int, error) {
+	revisionsKey := joinKey([]string{objectID, "revisions"})
+
+	ids, err := Conn.ZRange(revisionsKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	revisions := make(map[string]*Revision, len(ids))
+	for _, id := range ids {
+		revisions[id], err = LoadRevisionByID(id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// scores are in microseconds, revisions made on the same one are
+	// ordered by their times
+	sort.Sort(byWhen{ids, revisions})
+
+	keep, err := policy.keep(objectID, ids, revisions)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keep) == len(ids) {
+		return 0, nil
+	}
+
+	pipeline := Conn.Pipeline()
+	removed := 0
+
+	for _, id := range ids {
+		r := revisions[id]
+
+		if !keep[id] {
+			removeRevision(objectID, objectType, r, pipeline)
+			removed++
+			continue
+		}
+
+		// point to the closest kept ancestors
+		parent, mergeParent := "", ""
+		ancestors := keptAncestors([]string{r.Parent, r.MergeParent}, keep, revisions)
+		if len(ancestors) > 0 {
+			parent = ancestors[0]
+		}
+		if len(ancestors) > 1 {
+			mergeParent = ancestors[1]
+		}
+
+		if parent != r.Parent {
+			pipeline.HSet(id, "parent", parent)
+		}
+		if mergeParent != r.MergeParent {
+			pipeline.HSet(id, "merge_parent", mergeParent)
+		}
+	}
+
+	_, err = pipeline.Exec()
+	pipeline.Close()
+
+	return removed, err
+}
+
+// CompactAll compacts every doctype and document on the database,
+// including the documents deleted, found by their revisions.
+func CompactAll(policy RetentionPolicy) (int, error) {
+	removed := 0
+
+	objectIDs, err := revisedObjects()
+	if err != nil {
+		return removed, err
+	}
+
+	for _, id := range objectIDs {
+		n, err := Compact(id, policy)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// revisedObjects lists the objects having revisions, on the order of
+// their first revisions.
+func revisedObjects() ([]string, error) {
+	objectIDs := []string{}
+	seen := make(map[string]bool)
+
+	for start := int64(0); ; start += 1000 {
+		ids, err := Conn.ZRange("revisions", start, start+999).Result()
+		if err != nil {
+			return objectIDs, err
+		}
+
+		pipeline := Conn.Pipeline()
+		objects := make([]*redis.StringCmd, len(ids))
+		for i, id := range ids {
+			objects[i] = pipeline.HGet(id, "object")
+		}
+		_, err = pipeline.Exec()
+		pipeline.Close()
+		if err != nil && err != redis.Nil {
+			return objectIDs, err
+		}
+
+		for _, object := range objects {
+			id := object.Val()
+			if len(id) > 0 && !seen[id] {
+				seen[id] = true
+				objectIDs = append(objectIDs, id)
+			}
+		}
+
+		if len(ids) < 1000 {
+			return objectIDs, nil
+		}
+	}
+}
+
+// keep returns the set of revisions the policy keeps.
+// ids should be on chronological order.
+func (p RetentionPolicy) keep(objectID string, ids []string, revisions map[string]*Revision) (map[string]bool, error) {
+	keep := map[string]bool{}
+
+	// heads are never removed, the last revision being the head of
+	// the objects deleted
+	head := Conn.HGet(objectID, "revision").Val()
+	if len(head) == 0 && len(ids) > 0 {
+		head = ids[len(ids)-1]
+	}
+	if len(head) > 0 {
+		keep[head] = true
+	}
+
+	branches, err := Conn.HVals(joinKey([]string{objectID, "branches"})).Result()
+	if err != nil {
+		return keep, err
+	}
+	for _, id := range branches {
+		keep[id] = true
+	}
+
+	if p.KeepTagged {
+		tags, err := Conn.HVals(joinKey([]string{objectID, "tags"})).Result()
+		if err != nil {
+			return keep, err
+		}
+		for _, id := range tags {
+			keep[id] = true
+		}
+	}
+
+	if p.KeepLast > 0 {
+		start := len(ids) - p.KeepLast
+		if start < 0 {
+			start = 0
+		}
+		for _, id := range ids[start:] {
+			keep[id] = true
+		}
+	}
+
+	if p.KeepFor > 0 {
+		since := time.Now().UTC().Add(-p.KeepFor)
+		for _, id := range ids {
+			if revisions[id].When.After(since) {
+				keep[id] = true
+			}
+		}
+	}
+
+	return keep, nil
+}
+
+// keptAncestors follows the parents and merge parents of the revisions
+// until it finds kept ones, or the beginning of the chains.
+// First parents are followed first, so the first ancestor found is on
+// the same branch. Only the first two found can be pointed to.
+func keptAncestors(ids []string, keep map[string]bool, revisions map[string]*Revision) []string {
+	found := []string{}
+	seen := make(map[string]bool)
+
+	var follow func(id string)
+	follow = func(id string) {
+		if len(id) == 0 || seen[id] {
+			return
+		}
+		seen[id] = true
+
+		if keep[id] {
+			found = append(found, id)
+			return
+		}
+
+		r, ok := revisions[id]
+		if !ok {
+			// not one of the object's revisions anymore
+			return
+		}
+		follow(r.Parent)
+		follow(r.MergeParent)
+	}
+
+	for _, id := range ids {
+		follow(id)
+	}
+
+	return found
+}
+
+// removeRevision deletes the revision's hash and everything written
+// under it's ID.
+func removeRevision(objectID string, objectType string, r *Revision, pipeline *redis.Pipeline) {
+	pipeline.ZRem("revisions", r.ID)
+	pipeline.ZRem(changesKey, r.ID)
+	pipeline.ZRem(joinKey([]string{objectID, "revisions"}), r.ID)
+
+	keys := []string{r.ID}
+
+	switch objectType {
+	case "document":
+		keys = append(keys, joinKey([]string{r.ID, "values"}), joinKey([]string{r.ID, "extra"}))
+
+		// the revision's own, the document may have been deleted
+		doctypeID := Conn.HGet(r.ID, "doctype").Val()
+		fieldIDs := Conn.SMembers(joinKey([]string{doctypeID, "fields"})).Val()
+		for _, fieldID := range fieldIDs {
+			keys = append(keys, joinKey([]string{r.ID, "value", fieldID}))
+		}
+	case "doctype":
+		keys = append(keys, joinKey([]string{r.ID, "fields"}))
+
+		fieldIDs := Conn.SMembers(joinKey([]string{r.ID, "fields"})).Val()
+		for _, fieldID := range fieldIDs {
+			fieldKey := joinKey([]string{r.ID, "field", fieldID})
+			keys = append(keys, fieldKey, joinKey([]string{fieldKey, "expected_types"}))
+
+			pipeline.ZRem(joinKey([]string{objectID, "field", fieldID, "revisions"}), r.ID)
+		}
+	}
+
+	pipeline.Del(keys...)
+}
+
+type byWhen struct {
+	ids       []string
+	revisions map[string]*Revision
+}
+
+func (b byWhen) Len() int      { return len(b.ids) }
+func (b byWhen) Swap(i, j int) { b.ids[i], b.ids[j] = b.ids[j], b.ids[i] }
+func (b byWhen) Less(i, j int) bool {
+	return b.revisions[b.ids[i]].When.Before(b.revisions[b.ids[j]].When)
+}
@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	Convey("Create a document with many revisions", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "note",
+			"verbose_name": "Note",
+			"fields": {
+				"text": {
+					"verbose_name": "Text",
+					"expected_types": ["string"]
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+
+		doctypeCreated.Save()
+
+		documentCreated := &Document{
+			Slug:        "compacted-note",
+			DoctypeCode: "note",
+			Fields:      map[string]interface{}{"text": "1"},
+		}
+		documentCreated.Save()
+
+		revisions := []string{documentCreated.Revision.ID}
+		for _, text := range []string{"2", "3", "4", "5"} {
+			documentCreated.Fields["text"] = text
+			documentCreated.Save()
+			revisions = append(revisions, documentCreated.Revision.ID)
+		}
+
+		tagErr := TagRevision(documentCreated.ID, "v1", revisions[1])
+		if tagErr != nil {
+			panic(tagErr)
+		}
+
+		Convey("Compact keeping the last two and tagged revisions", func() {
+			removed, compactErr := Compact(documentCreated.ID, RetentionPolicy{KeepLast: 2, KeepTagged: true})
+			if compactErr != nil {
+				panic(compactErr)
+			}
+
+			So(removed, ShouldEqual, 2)
+
+			_, prunedErr := LoadRevisionByID(revisions[2])
+			So(prunedErr, ShouldNotBeNil)
+
+			kept, keptErr := LoadRevisionByID(revisions[3])
+			if keptErr != nil {
+				panic(keptErr)
+			}
+			So(kept.Parent, ShouldEqual, revisions[1])
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["text"], ShouldEqual, "5")
+		})
+
+		Convey("Documents being saved aren't compacted", func() {
+			unlock, lockErr := lockDocument(documentCreated.ID)
+			if lockErr != nil {
+				panic(lockErr)
+			}
+
+			timeout := SaveLockTimeout
+			SaveLockTimeout = 50 * time.Millisecond
+			_, compactErr := Compact(documentCreated.ID, RetentionPolicy{KeepLast: 1})
+			SaveLockTimeout = timeout
+			unlock()
+
+			So(compactErr, ShouldNotBeNil)
+			So(Conn.ZCard(joinKey([]string{documentCreated.ID, "revisions"})).Val(), ShouldEqual, 5)
+		})
+
+		Convey("Deleted documents are compacted too", func() {
+			So(documentCreated.Delete(), ShouldBeNil)
+
+			objects, objectsErr := revisedObjects()
+			if objectsErr != nil {
+				panic(objectsErr)
+			}
+			So(objects, ShouldContain, documentCreated.ID)
+
+			removed, compactErr := Compact(documentCreated.ID, RetentionPolicy{KeepLast: 1})
+			if compactErr != nil {
+				panic(compactErr)
+			}
+			So(removed, ShouldEqual, 5)
+
+			So(Conn.Exists(joinKey([]string{revisions[0], "values"})).Val(), ShouldBeFalse)
+
+			deleted, deletedErr := LoadRevisionByID(documentCreated.Revision.ID)
+			if deletedErr != nil {
+				panic(deletedErr)
+			}
+			So(deleted.Type, ShouldEqual, "delete")
+			So(deleted.Parent, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestKeptAncestors(t *testing.T) {
+	Convey("A merge removed from a history keeps both branches", t, func() {
+		// a <- b <- m <- head, with c branched from a and merged on m
+		revisions := map[string]*Revision{
+			"a":    {ID: "a"},
+			"b":    {ID: "b", Parent: "a"},
+			"c":    {ID: "c", Parent: "a"},
+			"m":    {ID: "m", Parent: "b", MergeParent: "c"},
+			"head": {ID: "head", Parent: "m"},
+		}
+		keep := map[string]bool{"a": true, "c": true, "head": true}
+
+		So(keptAncestors([]string{"m", ""}, keep, revisions), ShouldResemble, []string{"a", "c"})
+		So(keptAncestors([]string{"b", "c"}, keep, revisions), ShouldResemble, []string{"a", "c"})
+		So(keptAncestors([]string{"gone"}, keep, revisions), ShouldResemble, []string{})
+	})
+}
@@ -27,22 +27,49 @@ type Revision struct {
 	// Parent revision
 	Parent string `json:"parent"`
 
+	// Second parent, set when the revision merges two others
+	MergeParent string `json:"merge_parent,omitempty"`
+
 	// Object's ID on the database
 	Object string `json:"-"`
+
+	// Object's type, like doctype or document
+	ObjectType string `json:"-"`
 }
 
 // Save revision to the database.
 func (r *Revision) Save(client *redis.Pipeline) {
 	client.ZAdd("revisions", redis.Z{
-		Score:  float64(r.When.Unix()),
+		Score:  r.Score(),
 		Member: r.ID,
 	})
 
 	client.HSet(r.ID, "type", "revision")
 	client.HSet(r.ID, "object", r.Object)
+	client.HSet(r.ID, "object_type", r.ObjectType)
 	client.HSet(r.ID, "when", r.When.Format(time.RFC3339Nano))
 	client.HSet(r.ID, "change_type", r.Type)
 	client.HSet(r.ID, "parent", r.Parent)
+	client.HSet(r.ID, "merge_parent", r.MergeParent)
+	client.HSet(r.ID, "message", r.Message)
+}
+
+// Score used to sort revisions on sorted sets.
+// It's the time of the revision in microseconds, so revisions made on
+// the same second still keep their order.
+func (r *Revision) Score() float64 {
+	return float64(r.When.UnixNano() / int64(time.Microsecond))
+}
+
+// parents returns the IDs of the revisions this one descends from.
+func (r *Revision) parents() []string {
+	parents := []string{}
+	for _, parent := range []string{r.Parent, r.MergeParent} {
+		if len(parent) > 0 {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
 }
 
 // CreateRevision creates a revision meta to the object
@@ -63,34 +90,111 @@ func UpdateRevision(parent *Revision) *Revision {
 	revision.When = time.Now().UTC()
 	revision.Type = "update"
 	revision.Object = parent.Object
-	revision.Parent = parent.Parent
+	revision.ObjectType = parent.ObjectType
+	revision.Parent = parent.ID
+
+	return revision
+}
+
+// DeleteRevision creates a revision marking the object as deleted
+func DeleteRevision(parent *Revision) *Revision {
+	revision := UpdateRevision(parent)
+	revision.Type = "delete"
+
+	return revision
+}
+
+// MigrationRevision creates a revision made by migrations run on the object
+func MigrationRevision(parent *Revision) *Revision {
+	revision := UpdateRevision(parent)
+	revision.Type = "migration"
+
+	return revision
+}
+
+// MergeRevision creates a merge revision with two parents
+func MergeRevision(parent *Revision, mergeParent *Revision) *Revision {
+	revision := UpdateRevision(parent)
+	revision.Type = "merge"
+	revision.MergeParent = mergeParent.ID
 
 	return revision
 }
 
 // LoadRevisionByID loads a revision meta data from the database by ID.
 func LoadRevisionByID(id string) (*Revision, error) {
-	var err error
-
 	r := &Revision{}
 
 	// get all basic information from base hash
 	get := Conn.HGetAllMap(id).Val()
 
+	if len(get) == 0 {
+		return r, &NotFoundError{ObjectType: "revision", By: "ID", Keys: []string{id}}
+	}
+
+	return r, r.decode(id, get)
+}
+
+// loadRevisions loads the revisions by ID into the given map, on a
+// single round-trip.
+func loadRevisions(ids []string, revisions map[string]*Revision) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pipeline := Conn.Pipeline()
+	defer pipeline.Close()
+
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		hashes[i] = pipeline.HGetAllMap(id)
+	}
+
+	_, err := pipeline.Exec()
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for i, id := range ids {
+		get := hashes[i].Val()
+		if len(get) == 0 {
+			missing = append(missing, id)
+			continue
+		}
+
+		r := &Revision{}
+		err = r.decode(id, get)
+		if err != nil {
+			return err
+		}
+		revisions[id] = r
+	}
+
+	if len(missing) > 0 {
+		return &NotFoundError{ObjectType: "revision", By: "ID", Keys: missing}
+	}
+
+	return nil
+}
+
+// decode the revision from it's hash.
+func (r *Revision) decode(id string, get map[string]string) error {
+	var err error
+
 	if get["type"] != "revision" {
-		return r, fmt.Errorf("%s is type '%s', expecting 'revision'", id, get["type"])
+		return fmt.Errorf("%s is type '%s', expecting 'revision'", id, get["type"])
 	}
 
 	r.ID = id
 	r.Type = get["change_type"]
 	r.Object = get["object"]
+	r.ObjectType = get["object_type"]
 	r.Message = get["message"]
 	r.Parent = get["parent"]
+	r.MergeParent = get["merge_parent"]
 
 	r.When, err = time.Parse(time.RFC3339Nano, get["when"])
-	if err != nil {
-		return r, err
-	}
 
-	return r, nil
+	return err
 }
@@ -0,0 +1,116 @@
+package datastore
+
+import (
+	"fmt"
+)
+
+// Schema changes
+//
+// Each of the operations below changes the doctype's definition and
+// saves it as a new revision describing the change. Fields keep their
+// IDs through the changes, so documents already saved stay readable.
+
+// AddField adds a new field to the doctype.
+func (d *Doctype) AddField(code string, f *Field) error {
+	if _, ok := d.Fields[code]; ok {
+		return fmt.Errorf("Doctype %s already has a field %s", d.Code, code)
+	}
+
+	if d.Fields == nil {
+		d.Fields = make(map[string]*Field)
+	}
+	d.Fields[code] = f
+
+	return d.saveChange(fmt.Sprintf("Add field %s", code))
+}
+
+// RemoveField removes a field from the doctype. Values documents have
+// for it aren't loaded anymore, use DeprecateField to keep them.
+func (d *Doctype) RemoveField(code string) error {
+	if _, ok := d.Fields[code]; !ok {
+		return fmt.Errorf("Doctype %s has no field %s", d.Code, code)
+	}
+
+	if d.SlugField == code {
+		return fmt.Errorf("Field %s can't be removed, slugs are generated from it", code)
+	}
+
+	for _, group := range d.UniqueTogether {
+		for _, member := range group {
+			if member == code {
+				return fmt.Errorf("Field %s can't be removed, it's unique together with others", code)
+			}
+		}
+	}
+
+	delete(d.Fields, code)
+
+	return d.saveChange(fmt.Sprintf("Remove field %s", code))
+}
+
+// DeprecateField marks a field as deprecated.
+func (d *Doctype) DeprecateField(code string) error {
+	field, ok := d.Fields[code]
+	if !ok {
+		return fmt.Errorf("Doctype %s has no field %s", d.Code, code)
+	}
+
+	field.Deprecated = true
+
+	return d.saveChange(fmt.Sprintf("Deprecate field %s", code))
+}
+
+// RenameField changes the code of a field, and of the settings of the
+// doctype referring to it.
+func (d *Doctype) RenameField(from string, to string) error {
+	field, ok := d.Fields[from]
+	if !ok {
+		return fmt.Errorf("Doctype %s has no field %s", d.Code, from)
+	}
+
+	if _, ok := d.Fields[to]; ok {
+		return fmt.Errorf("Doctype %s already has a field %s", d.Code, to)
+	}
+
+	delete(d.Fields, from)
+	d.Fields[to] = field
+
+	if d.SlugField == from {
+		d.SlugField = to
+	}
+
+	for _, group := range d.UniqueTogether {
+		for i, member := range group {
+			if member == from {
+				group[i] = to
+			}
+		}
+	}
+
+	return d.saveChange(fmt.Sprintf("Rename field %s to %s", from, to))
+}
+
+// SetFieldVerboseName changes the human title of a field.
+func (d *Doctype) SetFieldVerboseName(code string, verboseName string) error {
+	field, ok := d.Fields[code]
+	if !ok {
+		return fmt.Errorf("Doctype %s has no field %s", d.Code, code)
+	}
+
+	field.VerboseName = verboseName
+
+	return d.saveChange(fmt.Sprintf("Set verbose name of field %s", code))
+}
+
+// SetVerboseName changes the human title of the doctype.
+func (d *Doctype) SetVerboseName(verboseName string) error {
+	d.VerboseName = verboseName
+
+	return d.saveChange("Set verbose name")
+}
+
+// saveChange saves the doctype with a revision described by the message.
+func (d *Doctype) saveChange(message string) error {
+	d.message = message
+	return d.Save()
+}
@@ -0,0 +1,99 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	Convey("Create a doctype and a document", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "recipe",
+			VerboseName: "Recipe",
+			Fields: map[string]*Field{
+				"title":   {VerboseName: "Title", ExpectedTypes: []string{"string"}},
+				"serves":  {VerboseName: "Serves", ExpectedTypes: []string{"int"}, Required: true},
+				"comment": {VerboseName: "Comment", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+		createdRevision := doctypeCreated.Revision
+
+		documentCreated := &Document{
+			DoctypeCode: "recipe",
+			Slug:        "pancakes",
+			Fields:      map[string]interface{}{"title": "Pancakes", "serves": 4, "comment": "yummy"},
+		}
+		So(documentCreated.Save(), ShouldBeNil)
+
+		Convey("Rename a field", func() {
+			So(doctypeCreated.RenameField("title", "name"), ShouldBeNil)
+			So(doctypeCreated.Revision.Type, ShouldEqual, "update")
+			So(doctypeCreated.Revision.Parent, ShouldEqual, createdRevision.ID)
+			So(doctypeCreated.Revision.Message, ShouldEqual, "Rename field title to name")
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["name"], ShouldEqual, "Pancakes")
+			So(documentLoaded.Fields, ShouldNotContainKey, "title")
+
+			So(doctypeCreated.RenameField("name", "serves"), ShouldNotBeNil)
+		})
+
+		Convey("Remove a field", func() {
+			So(doctypeCreated.RemoveField("comment"), ShouldBeNil)
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields, ShouldNotContainKey, "comment")
+			So(len(doctypeLoaded.Fields), ShouldEqual, 2)
+
+			// the document as it was is still readable
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["serves"], ShouldEqual, 4.0)
+		})
+
+		Convey("Deprecate a field", func() {
+			So(doctypeCreated.DeprecateField("serves"), ShouldBeNil)
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.Fields["serves"].Deprecated, ShouldBeTrue)
+
+			// deprecated fields aren't required anymore
+			other := &Document{
+				DoctypeCode: "recipe",
+				Slug:        "waffles",
+				Fields:      map[string]interface{}{"title": "Waffles"},
+			}
+			So(other.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.Fields["serves"], ShouldEqual, 4.0)
+		})
+
+		Convey("Change verbose names", func() {
+			So(doctypeCreated.SetFieldVerboseName("title", "Name"), ShouldBeNil)
+			So(doctypeCreated.SetVerboseName("Receita"), ShouldBeNil)
+
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByID(doctypeCreated.ID)
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+			So(doctypeLoaded.VerboseName, ShouldEqual, "Receita")
+			So(doctypeLoaded.Fields["title"].VerboseName, ShouldEqual, "Name")
+		})
+	})
+}
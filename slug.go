@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Slugify converts a text to a slug: lower case letters and
+// digits separated by dashes.
+func Slugify(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	return strings.Join(words, "-")
+}
+
+// generateSlug from the doctype's SlugField, or from the document's ID
+// when it has no value. When the slug is already used a suffix is
+// added to it, like my-page-2.
+func (d *Document) generateSlug() string {
+	base := ""
+	if value := d.Fields[d.Doctype.SlugField]; !isEmpty(value) {
+		base = Slugify(fmt.Sprint(value))
+	}
+	if len(base) == 0 {
+		base = d.ID
+	}
+
+	slugs := joinKey([]string{d.Doctype.ID, "slugs"})
+
+	slug := base
+	for n := 2; ; n++ {
+		owner := Conn.HGet(slugs, slug).Val()
+		if len(owner) == 0 || owner == d.ID {
+			return slug
+		}
+
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// claimedSlug returns the slug the document currently holds on it's
+// doctype.
+func (d *Document) claimedSlug() string {
+	slugs := joinKey([]string{d.Doctype.ID, "slugs"})
+	return Conn.HGet(joinKey([]string{d.ID, "unique"}), slugs).Val()
+}
+
+// LoadDocumentBySlug loads a document from the database by it's
+// doctype's code and slug. Slugs the document had before also lead
+// to it, check it's current Slug to redirect.
+func LoadDocumentBySlug(doctypeCode string, slug string) (*Document, error) {
+	doctypeID := Conn.HGet("doctypes", doctypeCode).Val()
+	if len(doctypeID) == 0 {
+		return &Document{}, &NotFoundError{ObjectType: "doctype", By: "code", Keys: []string{doctypeCode}}
+	}
+
+	id, err := documentIDBySlug(doctypeID, doctypeCode, slug)
+	if err != nil {
+		return &Document{Slug: slug, DoctypeCode: doctypeCode}, err
+	}
+
+	return LoadDocumentByID(id)
+}
+
+// documentIDBySlug finds the ID of the document by it's slug, current
+// or not, on the doctype.
+func documentIDBySlug(doctypeID string, doctypeCode string, slug string) (string, error) {
+	id := Conn.HGet(joinKey([]string{doctypeID, "slugs"}), slug).Val()
+	if len(id) == 0 {
+		id = Conn.HGet(joinKey([]string{doctypeID, "slug_history"}), slug).Val()
+	}
+
+	if len(id) == 0 {
+		return id, &NotFoundError{ObjectType: "document", By: "slug", Keys: []string{doctypeCode + "/" + slug}}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,124 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSlug(t *testing.T) {
+	Convey("Slugify texts", t, func() {
+		So(Slugify("My First Page!"), ShouldEqual, "my-first-page")
+		So(Slugify("  Olá,   mundo  "), ShouldEqual, "olá-mundo")
+	})
+
+	Convey("Create a doctype generating slugs", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "story",
+			VerboseName: "Story",
+			SlugField:   "title",
+			Fields: map[string]*Field{
+				"title": {VerboseName: "Title", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		first := &Document{
+			DoctypeCode: "story",
+			Fields:      map[string]interface{}{"title": "Breaking News"},
+		}
+		So(first.Save(), ShouldBeNil)
+		So(first.Slug, ShouldEqual, "breaking-news")
+
+		Convey("Collisions get a suffix", func() {
+			second := &Document{
+				DoctypeCode: "story",
+				Fields:      map[string]interface{}{"title": "Breaking news"},
+			}
+			So(second.Save(), ShouldBeNil)
+			So(second.Slug, ShouldEqual, "breaking-news-2")
+		})
+
+		Convey("Documents without the field get their ID", func() {
+			untitled := &Document{DoctypeCode: "story", Fields: map[string]interface{}{}}
+			So(untitled.Save(), ShouldBeNil)
+			So(untitled.Slug, ShouldEqual, untitled.ID)
+		})
+
+		Convey("Load by slug", func() {
+			documentLoaded, documentLoadedErr := LoadDocumentBySlug("story", "breaking-news")
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.ID, ShouldEqual, first.ID)
+
+			_, notFoundErr := LoadDocumentBySlug("story", "missing-news")
+			So(notFoundErr, ShouldNotBeNil)
+		})
+
+		Convey("Old slugs lead to the document", func() {
+			first.Slug = "old-news"
+			So(first.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentBySlug("story", "breaking-news")
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.ID, ShouldEqual, first.ID)
+			So(documentLoaded.Slug, ShouldEqual, "old-news")
+		})
+	})
+}
+
+func TestBackfillSlugs(t *testing.T) {
+	Convey("Create documents as saved before slugs were scoped by doctype", t, func() {
+		doctypeCreated := &Doctype{
+			Code:        "story",
+			VerboseName: "Story",
+			SlugField:   "title",
+			Fields: map[string]*Field{
+				"title": {VerboseName: "Title", ExpectedTypes: []string{"string"}},
+			},
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		slug := "legacy-" + GenerateID(4)
+		documentCreated := &Document{
+			DoctypeCode: "story",
+			Slug:        slug,
+			Fields:      map[string]interface{}{"title": "Legacy"},
+		}
+		So(documentCreated.Save(), ShouldBeNil)
+
+		slugs := joinKey([]string{doctypeCreated.ID, "slugs"})
+		Conn.HDel(slugs, slug)
+		Conn.HDel(joinKey([]string{documentCreated.ID, "unique"}), slugs)
+		Conn.HSet(legacySlugs, slug, documentCreated.ID)
+		Conn.HSet(legacySlugs, "older-"+slug, documentCreated.ID)
+
+		_, notFoundErr := LoadDocumentBySlug("story", slug)
+		So(notFoundErr, ShouldNotBeNil)
+
+		Convey("Backfill their slugs", func() {
+			added, backfillErr := BackfillSlugs()
+			if backfillErr != nil {
+				panic(backfillErr)
+			}
+			So(added, ShouldBeGreaterThanOrEqualTo, 2)
+
+			documentLoaded, documentLoadedErr := LoadDocumentBySlug("story", slug)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.ID, ShouldEqual, documentCreated.ID)
+
+			documentLoaded, documentLoadedErr = LoadDocumentBySlug("story", "older-"+slug)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+			So(documentLoaded.ID, ShouldEqual, documentCreated.ID)
+
+			// the slug is claimed, so it's still released on delete
+			So(documentLoaded.claimedSlug(), ShouldEqual, slug)
+		})
+	})
+}
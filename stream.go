@@ -0,0 +1,238 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/redis.v3"
+	"strconv"
+	"time"
+)
+
+// StreamKey is the Redis Stream every committed change is appended to.
+const StreamKey = "stream"
+
+// StreamMaxLen is about how many entries the stream keeps, the oldest
+// ones being trimmed as changes are appended. Zero keeps them all.
+var StreamMaxLen int64 = 100000
+
+// ClaimIdleTimeout is how long an entry stays pending on a consumer
+// before the others of the group take it over, as it's consumer is
+// taken for dead.
+var ClaimIdleTimeout = 5 * time.Minute
+
+// StreamEntry is a change read from the stream.
+type StreamEntry struct {
+	// Entry's ID on the stream, used to acknowledge it
+	ID string `json:"id"`
+
+	Event Event `json:"event"`
+}
+
+// Consumer reads the stream as a member of a consumer group.
+//
+// Every entry is delivered to a single consumer of the group and stays
+// pending until it's acknowledged, so entries aren't lost if the
+// consumer dies before processing them.
+type Consumer struct {
+	Group string
+	Name  string
+}
+
+// appendScript adds the change to the stream, trimming it to about
+// ARGV[1] entries unless it's zero, and remembers it's entry on the
+// revision, so the stream can be replayed from it.
+const appendScript = `
+local id
+if ARGV[1] == '0' then
+	id = redis.call('XADD', KEYS[1], '*', unpack(ARGV, 2))
+else
+	id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', unpack(ARGV, 2))
+end
+redis.call('HSET', KEYS[2], 'stream_id', id)
+return id
+`
+
+// readScript reads the group's entries, returning only the entries
+// of the single stream read.
+const readScript = `
+local res = redis.call('XREADGROUP', 'GROUP', ARGV[1], ARGV[2], 'COUNT', ARGV[3], 'STREAMS', KEYS[1], ARGV[4])
+if not res or not res[1] then
+	return {}
+end
+return res[1][2]
+`
+
+// reclaimScript takes over the group's entries pending for longer than
+// ARGV[3] milliseconds, returning the ones still on the stream.
+const reclaimScript = `
+local res = redis.call('XAUTOCLAIM', KEYS[1], ARGV[1], ARGV[2], ARGV[3], '0-0', 'COUNT', ARGV[4])
+local entries = {}
+for _, entry in ipairs(res[2]) do
+	if entry then
+		table.insert(entries, entry)
+	end
+end
+return entries
+`
+
+// groupScript creates the consumer group, if it doesn't exist yet.
+const groupScript = `
+local ok, err = pcall(redis.call, 'XGROUP', 'CREATE', KEYS[1], ARGV[1], ARGV[2], 'MKSTREAM')
+if not ok and not string.find(tostring(err), 'BUSYGROUP') then
+	return redis.error_reply(tostring(err))
+end
+return 'OK'
+`
+
+const rangeScript = `
+return redis.call('XRANGE', KEYS[1], ARGV[1], '+', 'COUNT', ARGV[2])
+`
+
+const ackScript = `
+return redis.call('XACK', KEYS[1], ARGV[1], unpack(ARGV, 2))
+`
+
+// appendChange adds the event to the stream on the same pipeline
+// the change is committed with.
+func appendChange(e Event, pipeline *redis.Pipeline) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+
+	pipeline.Eval(appendScript, []string{StreamKey, e.Revision}, []string{
+		strconv.FormatInt(StreamMaxLen, 10),
+		"revision", e.Revision,
+		"event", string(payload),
+	})
+}
+
+// NewConsumer joins the consumer group, creating it if needed.
+// A new group starts reading the changes made from now on.
+func NewConsumer(group string, name string) (*Consumer, error) {
+	err := Conn.Eval(groupScript, []string{StreamKey}, []string{group, "$"}).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Consumer{Group: group, Name: name}, nil
+}
+
+// Read up to count entries. Entries delivered before but not
+// acknowledged yet are returned first, then the ones left pending by
+// other consumers for longer than ClaimIdleTimeout, then new ones.
+func (c *Consumer) Read(count int) ([]StreamEntry, error) {
+	entries, err := c.read(count, "0")
+	if err != nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	entries, err = c.claim(count)
+	if err != nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	return c.read(count, ">")
+}
+
+func (c *Consumer) claim(count int) ([]StreamEntry, error) {
+	idle := int64(ClaimIdleTimeout / time.Millisecond)
+
+	res, err := Conn.Eval(reclaimScript, []string{StreamKey}, []string{
+		c.Group, c.Name, strconv.FormatInt(idle, 10), strconv.Itoa(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStreamEntries(res)
+}
+
+func (c *Consumer) read(count int, from string) ([]StreamEntry, error) {
+	res, err := Conn.Eval(readScript, []string{StreamKey}, []string{
+		c.Group, c.Name, strconv.Itoa(count), from,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStreamEntries(res)
+}
+
+// Ack acknowledges the entries were processed, so they aren't
+// delivered again.
+func (c *Consumer) Ack(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return Conn.Eval(ackScript, []string{StreamKey}, append([]string{c.Group}, ids...)).Err()
+}
+
+// Replay reads up to count entries of the stream made after the
+// given revision. An empty revision replays from the beginning.
+func Replay(afterRevision string, count int) ([]StreamEntry, error) {
+	start := "-"
+
+	if len(afterRevision) > 0 {
+		start = Conn.HGet(afterRevision, "stream_id").Val()
+		if len(start) == 0 {
+			return nil, fmt.Errorf("Revision %s is not on the stream", afterRevision)
+		}
+
+		// the range includes the revision's own entry
+		count++
+	}
+
+	res, err := Conn.Eval(rangeScript, []string{StreamKey}, []string{start, strconv.Itoa(count)}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseStreamEntries(res)
+	if err != nil {
+		return entries, err
+	}
+
+	if len(afterRevision) > 0 && len(entries) > 0 && entries[0].ID == start {
+		entries = entries[1:]
+	}
+
+	return entries, nil
+}
+
+// parseStreamEntries parses stream entries, as returned by XRANGE.
+func parseStreamEntries(res interface{}) ([]StreamEntry, error) {
+	entries := []StreamEntry{}
+
+	list, ok := res.([]interface{})
+	if !ok {
+		return entries, fmt.Errorf("Unexpected stream reply: %v", res)
+	}
+
+	for _, item := range list {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return entries, fmt.Errorf("Unexpected stream entry: %v", item)
+		}
+
+		e := StreamEntry{ID: fmt.Sprint(entry[0])}
+
+		// entries removed from the stream while pending have no fields
+		fields, _ := entry[1].([]interface{})
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fmt.Sprint(fields[i]) != "event" {
+				continue
+			}
+
+			err := json.Unmarshal([]byte(fmt.Sprint(fields[i+1])), &e.Event)
+			if err != nil {
+				return entries, err
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,96 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	Convey("Consume the stream with a consumer group", t, func() {
+		consumer, consumerErr := NewConsumer(GenerateID(4), "indexer")
+		if consumerErr != nil {
+			panic(consumerErr)
+		}
+
+		doctypeCreated := &Doctype{
+			Code:        "entry",
+			VerboseName: "Entry",
+			Fields: map[string]*Field{
+				"text": {VerboseName: "Text", ExpectedTypes: []string{"string"}},
+			},
+		}
+		doctypeCreated.Save()
+
+		documentCreated := &Document{
+			Slug:        "streamed-entry",
+			DoctypeCode: "entry",
+			Fields:      map[string]interface{}{"text": "Hello"},
+		}
+		documentCreated.Save()
+
+		entries, readErr := consumer.Read(10)
+		if readErr != nil {
+			panic(readErr)
+		}
+
+		So(entries, ShouldHaveLength, 2)
+		So(entries[0].Event.ObjectID, ShouldEqual, doctypeCreated.ID)
+		So(entries[1].Event.ObjectID, ShouldEqual, documentCreated.ID)
+		So(entries[1].Event.Revision, ShouldEqual, documentCreated.Revision.ID)
+
+		Convey("Unacknowledged entries are delivered again", func() {
+			again, againErr := consumer.Read(10)
+			if againErr != nil {
+				panic(againErr)
+			}
+
+			So(again, ShouldResemble, entries)
+
+			Convey("Acknowledged entries are not", func() {
+				ackErr := consumer.Ack(entries[0].ID, entries[1].ID)
+				if ackErr != nil {
+					panic(ackErr)
+				}
+
+				after, afterErr := consumer.Read(10)
+				if afterErr != nil {
+					panic(afterErr)
+				}
+
+				So(after, ShouldBeEmpty)
+			})
+		})
+
+		Convey("Entries left pending by a dead consumer are claimed", func() {
+			other, otherErr := NewConsumer(consumer.Group, "other-indexer")
+			if otherErr != nil {
+				panic(otherErr)
+			}
+
+			pending, pendingErr := other.Read(10)
+			if pendingErr != nil {
+				panic(pendingErr)
+			}
+			So(pending, ShouldBeEmpty)
+
+			timeout := ClaimIdleTimeout
+			ClaimIdleTimeout = 0
+			claimed, claimedErr := other.Read(10)
+			ClaimIdleTimeout = timeout
+			if claimedErr != nil {
+				panic(claimedErr)
+			}
+
+			So(claimed, ShouldResemble, entries)
+		})
+
+		Convey("Replay from a revision", func() {
+			replayed, replayErr := Replay(doctypeCreated.Revision.ID, 10)
+			if replayErr != nil {
+				panic(replayErr)
+			}
+
+			So(replayed[0].ID, ShouldEqual, entries[1].ID)
+		})
+	})
+}
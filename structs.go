@@ -0,0 +1,582 @@
+package datastore
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	documenterType    = reflect.TypeOf((*Documenter)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// structField is a field of a struct, as it's stored.
+type structField struct {
+	// Field's code, from it's json tag
+	code string
+
+	// Position of the field, for reflect's FieldByIndex
+	index []int
+
+	// Left out when empty, by the omitempty option of it's json tag
+	omitEmpty bool
+
+	typ reflect.StructField
+}
+
+// structFields lists the fields of the struct as encoding/json does:
+// the exported ones, named by their json tags, with the fields of
+// embedded structs promoted unless shadowed.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	promoted := []structField{}
+	seen := make(map[string]bool)
+
+	for i := 0; i < t.NumField(); i++ {
+		typeField := t.Field(i)
+
+		jsonTags := strings.Split(typeField.Tag.Get("json"), ",")
+		jsonName := jsonTags[0]
+
+		// if name is - then ignore it
+		if jsonName == "-" || typeField.Tag.Get("datastore") == "-" {
+			continue
+		}
+
+		fieldType := typeField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		// embedded structs without a name have their fields promoted
+		if typeField.Anonymous && jsonName == "" && fieldType.Kind() == reflect.Struct {
+			for _, embedded := range structFields(fieldType) {
+				embedded.index = append([]int{i}, embedded.index...)
+				promoted = append(promoted, embedded)
+			}
+			continue
+		}
+
+		// unexported fields aren't stored
+		if len(typeField.PkgPath) > 0 {
+			continue
+		}
+
+		// if there's no struct tag defining it's name use the struct's field name as default
+		if jsonName == "" {
+			jsonName = typeField.Name
+		}
+
+		omitEmpty := false
+		for _, option := range jsonTags[1:] {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+
+		seen[jsonName] = true
+		fields = append(fields, structField{code: jsonName, index: []int{i}, omitEmpty: omitEmpty, typ: typeField})
+	}
+
+	for _, embedded := range promoted {
+		if !seen[embedded.code] {
+			seen[embedded.code] = true
+			fields = append(fields, embedded)
+		}
+	}
+
+	return fields
+}
+
+// fieldFromType builds a field expecting values of the Go type.
+// Slices, except of bytes, and arrays are multiple values of their
+// elements' type.
+func fieldFromType(t reflect.Type) (*Field, error) {
+	f := &Field{}
+
+	if t.Kind() == reflect.Array || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		f.MultipleValues = true
+		t = t.Elem()
+	}
+
+	return f, f.expect(t)
+}
+
+// expect sets the field's ExpectedTypes after the Go type.
+func (f *Field) expect(t reflect.Type) error {
+	// pointers to Documenters are references to their documents
+	if t.Kind() == reflect.Ptr && t.Implements(documenterType) {
+		f.ExpectedTypes = []string{reflect.New(t.Elem()).Interface().(Documenter).DoctypeCode()}
+		return nil
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if isMarshaler(t) {
+		return f.expectMarshaled(t)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		f.ExpectedTypes = []string{TypeString}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.ExpectedTypes = []string{TypeInt}
+	case reflect.Float32, reflect.Float64:
+		f.ExpectedTypes = []string{TypeFloat}
+	case reflect.Bool:
+		f.ExpectedTypes = []string{TypeBool}
+	case reflect.Slice, reflect.Array:
+		// slices of bytes are encoded to JSON as base64 strings
+		if t.Kind() == reflect.Array || t.Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("Fields of type %s can't be stored, lists of lists aren't supported", t)
+		}
+		f.ExpectedTypes = []string{TypeString}
+	case reflect.Map:
+		f.ExpectedTypes = []string{TypeObject}
+	case reflect.Struct:
+		f.ExpectedTypes = []string{TypeObject}
+		f.Fields = make(map[string]*Field)
+
+		for _, structField := range structFields(t) {
+			field, err := fieldFromType(structField.typ.Type)
+			if err != nil {
+				return err
+			}
+
+			field.Code = structField.code
+			field.VerboseName = structField.typ.Name
+
+			err = parseFieldTag(nil, field, structField.typ.Tag.Get("datastore"))
+			if err != nil {
+				return err
+			}
+
+			f.Fields[field.Code] = field
+		}
+	case reflect.Interface:
+		f.ExpectedTypes = []string{TypeString, TypeFloat, TypeBool, TypeObject}
+	default:
+		return fmt.Errorf("Fields of type %s can't be stored", t)
+	}
+
+	return nil
+}
+
+// isMarshaler tells if the Go type is encoded to JSON by it's own
+// methods, like times.
+func isMarshaler(t reflect.Type) bool {
+	t = reflect.PtrTo(t)
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)
+}
+
+// expectMarshaled sets the field's ExpectedTypes after the JSON the Go
+// type is encoded to. Text marshalers are encoded as strings, the
+// other types as their zero value is, any value when that's null.
+func (f *Field) expectMarshaled(t reflect.Type) error {
+	anyValue := []string{TypeString, TypeFloat, TypeBool, TypeObject}
+
+	if !reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		f.ExpectedTypes = []string{TypeString}
+		return nil
+	}
+
+	encoded, err := json.Marshal(reflect.New(t).Interface())
+	if err != nil {
+		return fmt.Errorf("Fields of type %s can't be stored: %s", t, err)
+	}
+
+	switch encoded[0] {
+	case '"':
+		f.ExpectedTypes = []string{TypeString}
+	case '{':
+		f.ExpectedTypes = []string{TypeObject}
+	case 't', 'f':
+		f.ExpectedTypes = []string{TypeBool}
+	case 'n':
+		f.ExpectedTypes = anyValue
+	case '[':
+		if f.MultipleValues {
+			return fmt.Errorf("Fields of type %s can't be stored, lists of lists aren't supported", t)
+		}
+		f.MultipleValues = true
+		f.ExpectedTypes = anyValue
+	default:
+		f.ExpectedTypes = []string{TypeFloat}
+	}
+
+	return nil
+}
+
+// parseFieldTag sets the field's options from it's datastore tag, a
+// comma separated list of options like
+//
+//	datastore:"verbose=Full name,required,unique,min_length=3"
+//
+// Flags are required, unique, deprecated and slug, that generates the
+// doctype's slugs from the field. The other options set the field's
+// settings of the same name: verbose, min, max, min_length, max_length,
+// min_items, max_items, pattern, enum (values separated by |), default,
+// default_generator and computed.
+//
+// Values holding commas are quoted with single quotes, a quote inside
+// them written twice:
+//
+//	datastore:"pattern='^[a-z]{2,8}$',verbose='Author''s code'"
+func parseFieldTag(doctype *Doctype, f *Field, tag string) error {
+	if len(tag) == 0 {
+		return nil
+	}
+
+	options, err := splitTag(tag)
+	if err != nil {
+		return fmt.Errorf("Invalid datastore tag on field %s: %s", f.Code, err)
+	}
+
+	for _, option := range options {
+		name, value := option.name, option.value
+
+		switch name {
+		case "verbose":
+			f.VerboseName = value
+		case "required":
+			f.Required = true
+		case "unique":
+			f.Unique = true
+		case "deprecated":
+			f.Deprecated = true
+		case "slug":
+			if doctype == nil {
+				return fmt.Errorf("Field %s of a sub-document can't generate slugs", f.Code)
+			}
+			doctype.SlugField = f.Code
+		case "pattern":
+			f.Pattern = value
+		case "default_generator":
+			f.DefaultGenerator = value
+		case "computed":
+			f.Computed = value
+		case "default":
+			f.Default = tagValue(value)
+		case "enum":
+			for _, enumValue := range strings.Split(value, "|") {
+				f.Enum = append(f.Enum, tagValue(enumValue))
+			}
+		case "min", "max":
+			var number float64
+			number, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				break
+			}
+			if name == "min" {
+				f.Min = &number
+			} else {
+				f.Max = &number
+			}
+		case "min_length", "max_length", "min_items", "max_items":
+			var number int
+			number, err = strconv.Atoi(value)
+			if err != nil {
+				break
+			}
+			*map[string]**int{
+				"min_length": &f.MinLength,
+				"max_length": &f.MaxLength,
+				"min_items":  &f.MinItems,
+				"max_items":  &f.MaxItems,
+			}[name] = &number
+		default:
+			return fmt.Errorf("Unknown datastore option '%s' on field %s", name, f.Code)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Invalid datastore option '%s=%s' on field %s: %s", name, value, f.Code, err)
+		}
+	}
+
+	return nil
+}
+
+// tagOption is an option of a datastore tag, value is empty for flags.
+type tagOption struct {
+	name  string
+	value string
+}
+
+// splitTag splits a datastore tag in it's options, unquoting the
+// values quoted. See parseFieldTag.
+func splitTag(tag string) ([]tagOption, error) {
+	options := []tagOption{}
+
+	for len(tag) > 0 {
+		option := tagOption{}
+
+		end := strings.IndexAny(tag, "=,")
+		if end < 0 {
+			options = append(options, tagOption{name: tag})
+			break
+		}
+
+		option.name = tag[:end]
+		separator := tag[end]
+		tag = tag[end+1:]
+
+		if separator == ',' {
+			options = append(options, option)
+			continue
+		}
+
+		if !strings.HasPrefix(tag, "'") {
+			end = strings.Index(tag, ",")
+			if end < 0 {
+				end = len(tag)
+			}
+
+			option.value = tag[:end]
+			options = append(options, option)
+
+			tag = strings.TrimPrefix(tag[end:], ",")
+			continue
+		}
+
+		value := []byte{}
+		i := 1
+		for {
+			if i >= len(tag) {
+				return options, fmt.Errorf("Unterminated quote on option '%s'", option.name)
+			}
+
+			if tag[i] == '\'' {
+				// two quotes are a quote
+				if i+1 < len(tag) && tag[i+1] == '\'' {
+					value = append(value, '\'')
+					i += 2
+					continue
+				}
+				break
+			}
+
+			value = append(value, tag[i])
+			i++
+		}
+
+		option.value = string(value)
+		options = append(options, option)
+
+		tag = tag[i+1:]
+		if len(tag) > 0 && tag[0] != ',' {
+			return options, fmt.Errorf("Unexpected '%s' after the quoted value of option '%s'", tag, option.name)
+		}
+		tag = strings.TrimPrefix(tag, ",")
+	}
+
+	return options, nil
+}
+
+// tagValue decodes a value given on a tag as JSON, or takes it as a
+// string when it isn't.
+func tagValue(encoded string) interface{} {
+	var value interface{}
+	if json.Unmarshal([]byte(encoded), &value) != nil {
+		return encoded
+	}
+	return value
+}
+
+// isReferenceType tells if the Go type holds references to documents:
+// pointers to Documenters or slices of them.
+func isReferenceType(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Ptr && t.Implements(documenterType)
+}
+
+// structValues converts a Documenter to the values of it's document,
+// as encoding/json would. The Documenters it references are replaced
+// by their documents' IDs, found by their slugs, so references back to
+// the Documenter are stored as any other.
+func structValues(stru_doc Documenter) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	value := reflect.Indirect(reflect.ValueOf(stru_doc))
+
+	for _, structField := range structFields(value.Type()) {
+		fieldValue, ok := fieldByIndex(value, structField.index, false)
+		if !ok {
+			continue
+		}
+
+		if !isReferenceType(structField.typ.Type) {
+			if structField.omitEmpty && isEmptyValue(fieldValue) {
+				continue
+			}
+
+			encoded, err := json.Marshal(fieldValue.Interface())
+			if err != nil {
+				return values, fmt.Errorf("Field %s can't be stored: %s", structField.code, err)
+			}
+
+			var decoded interface{}
+			err = json.Unmarshal(encoded, &decoded)
+			if err != nil {
+				return values, fmt.Errorf("Field %s can't be stored: %s", structField.code, err)
+			}
+
+			values[structField.code] = decoded
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				values[structField.code] = nil
+				continue
+			}
+
+			id, err := documenterID(fieldValue.Interface().(Documenter))
+			if err != nil {
+				return values, err
+			}
+			values[structField.code] = id
+			continue
+		}
+
+		ids := []string{}
+		for i := 0; i < fieldValue.Len(); i++ {
+			if fieldValue.Index(i).IsNil() {
+				continue
+			}
+
+			id, err := documenterID(fieldValue.Index(i).Interface().(Documenter))
+			if err != nil {
+				return values, err
+			}
+			ids = append(ids, id)
+		}
+		values[structField.code] = ids
+	}
+
+	return values, nil
+}
+
+// isEmptyValue tells if encoding/json leaves the value out of fields
+// tagged omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// documenterID finds the ID of the Documenter's document by it's slug.
+func documenterID(stru_doc Documenter) (string, error) {
+	doctypeID := Conn.HGet("doctypes", stru_doc.DoctypeCode()).Val()
+	if len(doctypeID) == 0 {
+		return "", &NotFoundError{ObjectType: "doctype", By: "code", Keys: []string{stru_doc.DoctypeCode()}}
+	}
+
+	return documentIDBySlug(doctypeID, stru_doc.DoctypeCode(), stru_doc.Slug())
+}
+
+// decodeReferences loads the documents referenced by the values into
+// the Documenter's reference fields. loaded holds the Documenters
+// already loaded by ID, so references back to them are kept as they
+// are instead of being loaded again.
+func decodeReferences(values map[string]interface{}, stru_doc Documenter, loaded map[string]Documenter) error {
+	value := reflect.Indirect(reflect.ValueOf(stru_doc))
+
+	for _, structField := range structFields(value.Type()) {
+		if !isReferenceType(structField.typ.Type) || isEmpty(values[structField.code]) {
+			continue
+		}
+
+		fieldValue, _ := fieldByIndex(value, structField.index, true)
+
+		if fieldValue.Kind() == reflect.Ptr {
+			id, _ := values[structField.code].(string)
+
+			reference, err := loadReference(id, fieldValue.Type(), loaded)
+			if err != nil {
+				return err
+			}
+			fieldValue.Set(reference)
+			continue
+		}
+
+		ids := toStringSlice(values[structField.code])
+		references := reflect.MakeSlice(reflect.SliceOf(fieldValue.Type().Elem()), 0, len(ids))
+
+		for _, id := range ids {
+			reference, err := loadReference(id, fieldValue.Type().Elem(), loaded)
+			if err != nil {
+				return err
+			}
+			if !reference.IsNil() {
+				references = reflect.Append(references, reference)
+			}
+		}
+
+		if fieldValue.Kind() == reflect.Slice {
+			fieldValue.Set(references)
+		} else {
+			reflect.Copy(fieldValue, references)
+		}
+	}
+
+	return nil
+}
+
+// loadReference loads the referenced document into a new Documenter of
+// the given pointer type. Documents not found anymore are left nil.
+func loadReference(id string, t reflect.Type, loaded map[string]Documenter) (reflect.Value, error) {
+	if stru_doc, ok := loaded[id]; ok && reflect.TypeOf(stru_doc) == t {
+		return reflect.ValueOf(stru_doc), nil
+	}
+
+	reference := reflect.New(t.Elem())
+
+	documentLoaded, err := LoadDocumentByID(id)
+	if IsNotFound(err) {
+		return reflect.Zero(t), nil
+	}
+	if err != nil {
+		return reference, err
+	}
+
+	return reference, decodeStruct(documentLoaded, reference.Interface().(Documenter), loaded)
+}
+
+// fieldByIndex returns the struct's nested field. Embedded structs'
+// nil pointers are allocated when alloc is set, otherwise the field
+// isn't found.
+func fieldByIndex(value reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, position := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				if !alloc {
+					return value, false
+				}
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(position)
+	}
+
+	return value, true
+}
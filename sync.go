@@ -0,0 +1,449 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegisterLockTimeout is how long a process waits for others to finish
+// registering a doctype, and how long it may hold the registration.
+var RegisterLockTimeout = 10 * time.Second
+
+// SchemaChange is a difference between a doctype's definition on the
+// database and the one registered.
+type SchemaChange struct {
+	// Field's code, empty for changes to the doctype itself
+	Field string `json:"field,omitempty"`
+
+	// Kind of change: add, deprecate or change
+	Kind string `json:"kind"`
+
+	// Description of the change
+	Detail string `json:"detail"`
+
+	// Compatible changes keep the documents already saved valid.
+	Compatible bool `json:"compatible"`
+}
+
+// String implements fmt.Stringer
+func (c SchemaChange) String() string {
+	if len(c.Field) == 0 {
+		return fmt.Sprintf("%s doctype: %s", c.Kind, c.Detail)
+	}
+	return fmt.Sprintf("%s field %s: %s", c.Kind, c.Field, c.Detail)
+}
+
+// IncompatibleSchemaError is returned when a doctype registered has
+// changes that would make the documents already saved invalid.
+type IncompatibleSchemaError struct {
+	Code    string
+	Changes []SchemaChange
+}
+
+// Error implements error
+func (e *IncompatibleSchemaError) Error() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, change.String())
+	}
+	return fmt.Sprintf("Incompatible changes to doctype %s: %s", e.Code, strings.Join(changes, "; "))
+}
+
+// DiffSchema lists the changes that turn the doctype's definition from
+// into to. Fields missing on to are deprecated, not removed, so their
+// values stay readable. Fields of sub-documents are compared the same
+// way, named by their path like settings.theme.
+func DiffSchema(from *Doctype, to *Doctype) ([]SchemaChange, error) {
+	changes := []SchemaChange{}
+
+	if from.VerboseName != to.VerboseName {
+		changes = append(changes, SchemaChange{
+			Kind:       "change",
+			Detail:     fmt.Sprintf("verbose name from '%s' to '%s'", from.VerboseName, to.VerboseName),
+			Compatible: true,
+		})
+	}
+
+	if from.SlugField != to.SlugField {
+		changes = append(changes, SchemaChange{
+			Kind:       "change",
+			Detail:     fmt.Sprintf("slug field from '%s' to '%s'", from.SlugField, to.SlugField),
+			Compatible: true,
+		})
+	}
+
+	if from.Strict != to.Strict {
+		changes = append(changes, SchemaChange{
+			Kind:       "change",
+			Detail:     fmt.Sprintf("strict from %t to %t", from.Strict, to.Strict),
+			Compatible: !to.Strict,
+		})
+	}
+
+	fieldChanges, err := diffFields(from.Fields, to.Fields, "")
+	return append(changes, fieldChanges...), err
+}
+
+// diffFields lists the changes that turn the fields from into to, their
+// codes prefixed by path.
+func diffFields(from map[string]*Field, to map[string]*Field, path string) ([]SchemaChange, error) {
+	changes := []SchemaChange{}
+
+	codes := []string{}
+	for code := range from {
+		codes = append(codes, code)
+	}
+	for code := range to {
+		if _, ok := from[code]; !ok {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		before, after := from[code], to[code]
+
+		switch {
+		case before == nil:
+			// documents already saved have no value for it
+			required := after.Required && after.Default == nil && len(after.DefaultGenerator) == 0
+			changes = append(changes, SchemaChange{
+				Field:      path + code,
+				Kind:       "add",
+				Detail:     fmt.Sprintf("expecting %s", strings.Join(after.ExpectedTypes, " or ")),
+				Compatible: !required,
+			})
+		case after == nil:
+			if len(before.InheritedFrom) == 0 && !before.Deprecated {
+				changes = append(changes, SchemaChange{
+					Field:      path + code,
+					Kind:       "deprecate",
+					Detail:     "missing on the registered definition",
+					Compatible: true,
+				})
+			}
+		default:
+			same, err := sameDefinition(before, after)
+			if err != nil {
+				return changes, err
+			}
+			if same {
+				continue
+			}
+
+			fieldChanges, err := diffField(path+code, before, after)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, fieldChanges...)
+		}
+	}
+
+	return changes, nil
+}
+
+// diffField lists the changes made to a field's definition, the field
+// named by it's path.
+func diffField(path string, before *Field, after *Field) ([]SchemaChange, error) {
+	changes := []SchemaChange{}
+
+	add := func(detail string, compatible bool) {
+		changes = append(changes, SchemaChange{Field: path, Kind: "change", Detail: detail, Compatible: compatible})
+	}
+
+	beforeTypes, afterTypes := sortedTypes(before), sortedTypes(after)
+	if strings.Join(beforeTypes, ",") != strings.Join(afterTypes, ",") {
+		add(fmt.Sprintf("expected types from %v to %v", beforeTypes, afterTypes), widens(beforeTypes, afterTypes))
+	}
+
+	if before.MultipleValues != after.MultipleValues {
+		add(fmt.Sprintf("multiple values from %t to %t", before.MultipleValues, after.MultipleValues), false)
+	}
+
+	if before.Required != after.Required {
+		add(fmt.Sprintf("required from %t to %t", before.Required, after.Required), !after.Required)
+	}
+
+	if before.Unique != after.Unique {
+		add(fmt.Sprintf("unique from %t to %t", before.Unique, after.Unique), !after.Unique)
+	}
+
+	if before.Deprecated != after.Deprecated {
+		add(fmt.Sprintf("deprecated from %t to %t", before.Deprecated, after.Deprecated), true)
+	}
+
+	// validation rules may only be loosened or removed, tighter ones
+	// could refuse the documents already saved.
+	if before.Pattern != after.Pattern {
+		add(fmt.Sprintf("pattern from '%s' to '%s'", before.Pattern, after.Pattern), len(after.Pattern) == 0)
+	}
+
+	same, err := sameValues(before.Enum, after.Enum)
+	if err != nil {
+		return changes, err
+	}
+	if !same {
+		loosened, err := containsValues(after.Enum, before.Enum)
+		if err != nil {
+			return changes, err
+		}
+		add(fmt.Sprintf("enum from %v to %v", before.Enum, after.Enum), len(after.Enum) == 0 || loosened)
+	}
+
+	for _, limit := range []struct {
+		name          string
+		before, after *float64
+		minimum       bool
+	}{
+		{"min", before.Min, after.Min, true},
+		{"max", before.Max, after.Max, false},
+		{"min length", intLimit(before.MinLength), intLimit(after.MinLength), true},
+		{"max length", intLimit(before.MaxLength), intLimit(after.MaxLength), false},
+		{"min items", intLimit(before.MinItems), intLimit(after.MinItems), true},
+		{"max items", intLimit(before.MaxItems), intLimit(after.MaxItems), false},
+	} {
+		if formatLimit(limit.before) != formatLimit(limit.after) {
+			add(fmt.Sprintf("%s from %s to %s", limit.name, formatLimit(limit.before), formatLimit(limit.after)), loosens(limit.before, limit.after, limit.minimum))
+		}
+	}
+
+	// the fields of sub-documents follow the same rules
+	fieldChanges, err := diffFields(before.Fields, after.Fields, path+".")
+	if err != nil {
+		return changes, err
+	}
+	changes = append(changes, fieldChanges...)
+
+	// anything else, like the verbose name or validation rules,
+	// is only checked when documents are saved again.
+	if len(changes) == 0 {
+		add("settings", true)
+	}
+
+	return changes, nil
+}
+
+// widens tells if all the values of the types from are still accepted
+// by the types to.
+func widens(from []string, to []string) bool {
+	accepted := stringSet(to)
+	for _, expectedType := range from {
+		if accepted[expectedType] {
+			continue
+		}
+		// ints are floats too
+		if expectedType == TypeInt && accepted[TypeFloat] {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// loosens tells if the limit after accepts all the values the limit
+// before did. nil is no limit, minimum tells the limits are lower ones.
+func loosens(before *float64, after *float64, minimum bool) bool {
+	if after == nil {
+		return true
+	}
+	if before == nil {
+		return false
+	}
+	if minimum {
+		return *after <= *before
+	}
+	return *after >= *before
+}
+
+func intLimit(limit *int) *float64 {
+	if limit == nil {
+		return nil
+	}
+	number := float64(*limit)
+	return &number
+}
+
+func formatLimit(limit *float64) string {
+	if limit == nil {
+		return "none"
+	}
+	return strconv.FormatFloat(*limit, 'f', -1, 64)
+}
+
+// containsValues tells if all the values are among the options,
+// compared by their JSON encoding.
+func containsValues(options []interface{}, values []interface{}) (bool, error) {
+	encoded := make(map[string]bool, len(options))
+	for _, option := range options {
+		key, err := json.Marshal(option)
+		if err != nil {
+			return false, err
+		}
+		encoded[string(key)] = true
+	}
+
+	for _, value := range values {
+		key, err := json.Marshal(value)
+		if err != nil {
+			return false, err
+		}
+		if !encoded[string(key)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func sameValues(a []interface{}, b []interface{}) (bool, error) {
+	contained, err := containsValues(a, b)
+	if err != nil || !contained {
+		return false, err
+	}
+	return containsValues(b, a)
+}
+
+// sameDefinition tells if the fields are defined the same way, no
+// matter their IDs and revisions.
+func sameDefinition(a *Field, b *Field) (bool, error) {
+	definitionA, err := json.Marshal(definition(a))
+	if err != nil {
+		return false, err
+	}
+
+	definitionB, err := json.Marshal(definition(b))
+	if err != nil {
+		return false, err
+	}
+
+	return string(definitionA) == string(definitionB), nil
+}
+
+// definition of the field to compare, without it's ID and revision
+// nor the ones of it's sub-documents' fields.
+func definition(f *Field) *Field {
+	copied := *f
+	copied.ID = ""
+	copied.Revision = nil
+	copied.ExpectedTypes = sortedTypes(f)
+
+	if len(f.Fields) > 0 {
+		copied.Fields = make(map[string]*Field, len(f.Fields))
+		for code, field := range f.Fields {
+			copied.Fields[code] = definition(field)
+		}
+	}
+
+	return &copied
+}
+
+func sortedTypes(f *Field) []string {
+	types := append([]string{}, f.ExpectedTypes...)
+	sort.Strings(types)
+	return types
+}
+
+// SyncDoctype registers a Go Struct as a Doctype, like RegisterDoctype,
+// reporting instead of panicking.
+//
+// When the doctype already exists it's definition is compared to the
+// struct's: compatible changes are saved as a new revision, keeping the
+// fields' IDs, and nothing is saved when there are none. Incompatible
+// changes save nothing and return an *IncompatibleSchemaError.
+// The changes found are returned either way.
+func SyncDoctype(doctype Documenter) (*Doctype, []SchemaChange, error) {
+	registered, err := doctypeFromStruct(doctype)
+	if err != nil {
+		return registered, nil, err
+	}
+
+	synced := registered
+	var changes []SchemaChange
+
+	// it's saved as a single operation, the definition registered
+	// being the one interceptors get.
+	op := &Operation{Kind: OpSave, ObjectType: "doctype", Object: registered}
+	err = intercept(op, func() (err error) {
+		synced, changes, err = syncDoctype(registered)
+		op.ID = synced.ID
+		return err
+	})
+
+	return synced, changes, err
+}
+
+func syncDoctype(registered *Doctype) (*Doctype, []SchemaChange, error) {
+	unlock, err := lockDoctype(registered.Code)
+	if err != nil {
+		return registered, nil, err
+	}
+	defer unlock()
+
+	// the registry may not have received the changes registered by the
+	// process that held the lock before.
+	Doctypes.Invalidate()
+
+	existing, err := LoadDoctypeByCode(registered.Code)
+	if IsNotFound(err) {
+		return registered, nil, registered.save()
+	}
+	if err != nil {
+		return registered, nil, err
+	}
+
+	// fields deprecated with DeprecateField stay deprecated, the
+	// structs still having them for the older documents.
+	for code, field := range registered.Fields {
+		if before, ok := existing.Fields[code]; ok && before.Deprecated {
+			field.Deprecated = true
+		}
+	}
+
+	changes, err := DiffSchema(existing, registered)
+	if err != nil {
+		return existing, changes, err
+	}
+	if len(changes) == 0 {
+		return existing, changes, nil
+	}
+
+	incompatible := []SchemaChange{}
+	for _, change := range changes {
+		if !change.Compatible {
+			incompatible = append(incompatible, change)
+		}
+	}
+	if len(incompatible) > 0 {
+		return existing, changes, &IncompatibleSchemaError{Code: registered.Code, Changes: incompatible}
+	}
+
+	existing.VerboseName = registered.VerboseName
+	existing.SlugField = registered.SlugField
+	existing.Strict = registered.Strict
+
+	for code, field := range existing.Fields {
+		if _, ok := registered.Fields[code]; !ok && len(field.InheritedFrom) == 0 {
+			field.Deprecated = true
+		}
+	}
+	for code, field := range registered.Fields {
+		if before, ok := existing.Fields[code]; ok {
+			field.ID = before.ID
+		}
+		existing.Fields[code] = field
+	}
+
+	existing.message = fmt.Sprintf("Register %s", registered.Code)
+
+	return existing, changes, existing.save()
+}
+
+// lockDoctype waits to take a lock on the doctype's code, so processes
+// don't register it at the same time.
+// Returns the function releasing it.
+func lockDoctype(code string) (func(), error) {
+	return lock(joinKey([]string{"doctypes", code, "lock"}), RegisterLockTimeout)
+}
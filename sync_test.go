@@ -0,0 +1,194 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"testing"
+)
+
+type GadgetV1 struct {
+	Name string `json:"name"`
+	code string
+}
+
+func (g *GadgetV1) Slug() string        { return Slugify(g.Name) }
+func (g *GadgetV1) DoctypeCode() string { return g.code }
+
+type GadgetV2 struct {
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+	code  string
+}
+
+func (g *GadgetV2) Slug() string        { return Slugify(g.Name) }
+func (g *GadgetV2) DoctypeCode() string { return g.code }
+
+type GadgetV3 struct {
+	Name  bool    `json:"name"`
+	Price float64 `json:"price"`
+	Stock int     `json:"stock" datastore:"required"`
+	code  string
+}
+
+func (g *GadgetV3) Slug() string        { return "" }
+func (g *GadgetV3) DoctypeCode() string { return g.code }
+
+func TestSyncDoctype(t *testing.T) {
+	Convey("Register a doctype", t, func() {
+		code := "gadget-" + GenerateID(4)
+
+		registered, changes, err := SyncDoctype(&GadgetV1{code: code})
+		if err != nil {
+			panic(err)
+		}
+		So(changes, ShouldBeEmpty)
+		nameID := registered.Fields["name"].ID
+
+		Convey("Registering it again changes nothing", func() {
+			again, changes, err := SyncDoctype(&GadgetV1{code: code})
+			if err != nil {
+				panic(err)
+			}
+			So(changes, ShouldBeEmpty)
+			So(again.ID, ShouldEqual, registered.ID)
+			So(again.Revision.ID, ShouldEqual, registered.Revision.ID)
+		})
+
+		Convey("Compatible changes are saved", func() {
+			updated, changes, err := SyncDoctype(&GadgetV2{code: code})
+			if err != nil {
+				panic(err)
+			}
+			So(changes, ShouldResemble, []SchemaChange{
+				{Kind: "change", Detail: "verbose name from 'GadgetV1' to 'GadgetV2'", Compatible: true},
+				{Field: "price", Kind: "add", Detail: "expecting int", Compatible: true},
+			})
+			So(updated.ID, ShouldEqual, registered.ID)
+			So(updated.Revision.Parent, ShouldEqual, registered.Revision.ID)
+			So(updated.Fields["name"].ID, ShouldEqual, nameID)
+
+			Convey("Fields missing are deprecated", func() {
+				downgraded, changes, err := SyncDoctype(&GadgetV1{code: code})
+				if err != nil {
+					panic(err)
+				}
+				So(changes[1].Kind, ShouldEqual, "deprecate")
+				So(downgraded.Fields["price"].Deprecated, ShouldBeTrue)
+			})
+
+			Convey("Fields deprecated stay deprecated", func() {
+				So(updated.DeprecateField("price"), ShouldBeNil)
+
+				again, changes, err := SyncDoctype(&GadgetV2{code: code})
+				if err != nil {
+					panic(err)
+				}
+				So(changes, ShouldBeEmpty)
+				So(again.Fields["price"].Deprecated, ShouldBeTrue)
+			})
+
+			Convey("Incompatible changes are refused", func() {
+				_, changes, err := SyncDoctype(&GadgetV3{code: code})
+				So(err, ShouldHaveSameTypeAs, &IncompatibleSchemaError{})
+				So(len(changes), ShouldEqual, 4)
+
+				incompatible := err.(*IncompatibleSchemaError).Changes
+				So(len(incompatible), ShouldEqual, 2)
+				So(incompatible[0].Field, ShouldEqual, "name")
+				So(incompatible[1].Field, ShouldEqual, "stock")
+
+				So(func() { RegisterDoctype(&GadgetV3{code: code}) }, ShouldPanic)
+
+				doctypeLoaded, doctypeLoadedErr := LoadDoctypeByCode(code)
+				if doctypeLoadedErr != nil {
+					panic(doctypeLoadedErr)
+				}
+				So(doctypeLoaded.Revision.ID, ShouldEqual, updated.Revision.ID)
+			})
+		})
+	})
+}
+
+func TestDiffValidationRules(t *testing.T) {
+	Convey("Diff the validation rules of a field", t, func() {
+		five, ten := 5.0, 10.0
+		three := 3
+
+		diff := func(before *Field, after *Field) []SchemaChange {
+			before.Code, after.Code = "code", "code"
+			before.ExpectedTypes, after.ExpectedTypes = []string{"string"}, []string{"string"}
+			changes, err := DiffSchema(
+				&Doctype{Fields: map[string]*Field{"code": before}},
+				&Doctype{Fields: map[string]*Field{"code": after}},
+			)
+			if err != nil {
+				panic(err)
+			}
+			return changes
+		}
+
+		Convey("Tightened rules are incompatible", func() {
+			for _, changes := range [][]SchemaChange{
+				diff(&Field{}, &Field{Pattern: "^[a-z]+$"}),
+				diff(&Field{Pattern: "^[a-z]+$"}, &Field{Pattern: "^[a-c]+$"}),
+				diff(&Field{Enum: []interface{}{"a", "b"}}, &Field{Enum: []interface{}{"a"}}),
+				diff(&Field{Min: &five}, &Field{Min: &ten}),
+				diff(&Field{Max: &ten}, &Field{Max: &five}),
+				diff(&Field{}, &Field{MinLength: &three}),
+				diff(&Field{}, &Field{MaxItems: &three}),
+			} {
+				So(changes, ShouldHaveLength, 1)
+				So(changes[0].Compatible, ShouldBeFalse)
+			}
+		})
+
+		Convey("Loosened or removed rules are compatible", func() {
+			for _, changes := range [][]SchemaChange{
+				diff(&Field{Pattern: "^[a-z]+$"}, &Field{}),
+				diff(&Field{Enum: []interface{}{"a"}}, &Field{Enum: []interface{}{"a", "b"}}),
+				diff(&Field{Min: &ten}, &Field{Min: &five}),
+				diff(&Field{Max: &five}, &Field{}),
+				diff(&Field{MinLength: &three}, &Field{}),
+			} {
+				So(changes, ShouldHaveLength, 1)
+				So(changes[0].Compatible, ShouldBeTrue)
+			}
+		})
+
+		Convey("Fields of sub-documents are compared the same way", func() {
+			settings := func(fields map[string]*Field) *Field {
+				return &Field{Fields: fields}
+			}
+			theme := func(expectedType string, required bool) *Field {
+				return &Field{Code: "theme", ExpectedTypes: []string{expectedType}, Required: required}
+			}
+
+			changes := diff(settings(map[string]*Field{"theme": theme("string", false)}), settings(map[string]*Field{"theme": theme("int", false)}))
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].Field, ShouldEqual, "code.theme")
+			So(changes[0].Compatible, ShouldBeFalse)
+
+			changes = diff(settings(map[string]*Field{}), settings(map[string]*Field{"theme": theme("string", true)}))
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].Kind, ShouldEqual, "add")
+			So(changes[0].Compatible, ShouldBeFalse)
+
+			changes = diff(settings(map[string]*Field{}), settings(map[string]*Field{"theme": theme("string", false)}))
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].Compatible, ShouldBeTrue)
+
+			// only the IDs differ
+			before, after := theme("string", false), theme("string", false)
+			before.ID, after.ID = GenerateID(4), GenerateID(4)
+			So(diff(settings(map[string]*Field{"theme": before}), settings(map[string]*Field{"theme": after})), ShouldBeEmpty)
+		})
+
+		Convey("Values that can't be encoded are reported", func() {
+			_, err := DiffSchema(
+				&Doctype{Fields: map[string]*Field{"code": {Default: 1.0}}},
+				&Doctype{Fields: map[string]*Field{"code": {Default: math.NaN()}}},
+			)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
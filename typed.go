@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	"reflect"
+)
+
+// Get loads a document from the database by ID into a new T, like
+// LoadInto. T is usually a pointer to the struct registered with
+// RegisterDoctype.
+func Get[T Documenter](id string) (T, error) {
+	stru_doc, into := newDocumenter[T]()
+
+	_, err := LoadInto(id, into)
+
+	return *stru_doc, err
+}
+
+// GetBySlug loads a document from the database by slug into a new T.
+// The doctype is the one of T.
+func GetBySlug[T Documenter](slug string) (T, error) {
+	stru_doc, into := newDocumenter[T]()
+
+	documentLoaded, err := LoadDocumentBySlug(into.DoctypeCode(), slug)
+	if err != nil {
+		return *stru_doc, err
+	}
+
+	return *stru_doc, decodeInto(documentLoaded, into)
+}
+
+// GetMany loads many documents from the database by ID into new Ts,
+// reading them together like LoadDocuments.
+func GetMany[T Documenter](ids ...string) ([]T, error) {
+	stru_docs := make([]T, 0, len(ids))
+
+	documents, err := LoadDocuments(ids...)
+	if err != nil {
+		return stru_docs, err
+	}
+
+	for _, documentLoaded := range documents {
+		stru_doc, into := newDocumenter[T]()
+
+		err = decodeInto(documentLoaded, into)
+		if err != nil {
+			return stru_docs, err
+		}
+
+		stru_docs = append(stru_docs, *stru_doc)
+	}
+
+	return stru_docs, nil
+}
+
+// newDocumenter allocates a T, and the Documenter values should be
+// decoded into: the T itself when it's a pointer, or a pointer to it.
+func newDocumenter[T Documenter]() (*T, Documenter) {
+	stru_doc := new(T)
+
+	documenterType := reflect.TypeOf(stru_doc).Elem()
+	if documenterType.Kind() == reflect.Ptr {
+		*stru_doc = reflect.New(documenterType.Elem()).Interface().(T)
+		return stru_doc, *stru_doc
+	}
+
+	return stru_doc, any(stru_doc).(Documenter)
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type Book struct {
+	ISBN   string `json:"isbn"`
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+}
+
+func (b *Book) Slug() string {
+	return b.ISBN
+}
+
+func (b *Book) DoctypeCode() string {
+	return "book"
+}
+
+func TestTyped(t *testing.T) {
+	Convey("Register a Documenter and save it", t, func() {
+		RegisterDoctype(&Book{})
+
+		book := &Book{ISBN: "978-" + GenerateID(4), Title: "Go", Author: "Gopher"}
+		documentCreated, documentCreatedErr := CreateDocument(book)
+		if documentCreatedErr != nil {
+			panic(documentCreatedErr)
+		}
+
+		Convey("Load it into a struct", func() {
+			loaded := &Book{}
+			_, loadedErr := LoadInto(documentCreated.ID, loaded)
+			if loadedErr != nil {
+				panic(loadedErr)
+			}
+			So(loaded, ShouldResemble, book)
+		})
+
+		Convey("Get it typed", func() {
+			loaded, loadedErr := Get[*Book](documentCreated.ID)
+			if loadedErr != nil {
+				panic(loadedErr)
+			}
+			So(loaded, ShouldResemble, book)
+
+			bySlug, bySlugErr := GetBySlug[*Book](book.ISBN)
+			if bySlugErr != nil {
+				panic(bySlugErr)
+			}
+			So(bySlug.Title, ShouldEqual, "Go")
+
+			many, manyErr := GetMany[*Book](documentCreated.ID)
+			if manyErr != nil {
+				panic(manyErr)
+			}
+			So(many, ShouldResemble, []*Book{book})
+		})
+
+		Convey("Documents of other doctypes are refused", func() {
+			RegisterDoctype(&Comment{})
+
+			comment := &Comment{Code: GenerateID(4), Text: "Nice book"}
+			commentCreated, commentCreatedErr := CreateDocument(comment)
+			if commentCreatedErr != nil {
+				panic(commentCreatedErr)
+			}
+
+			_, loadedErr := Get[*Book](commentCreated.ID)
+			So(loadedErr, ShouldNotBeNil)
+		})
+	})
+}
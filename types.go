@@ -0,0 +1,206 @@
+package datastore
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Types of values a field can expect.
+// Any other type is taken as the code of a doctype, and the field
+// expects the ID of a document of that doctype.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+
+	// Sub-documents, whose values are defined by the field's Fields
+	TypeObject = "object"
+)
+
+// builtinTypes are the types that aren't references to documents.
+var builtinTypes = map[string]bool{
+	TypeString: true,
+	TypeInt:    true,
+	TypeFloat:  true,
+	TypeBool:   true,
+	TypeObject: true,
+}
+
+// goTypes are the names of the Go types doctypes registered from structs
+// used to expect, before Go types were mapped to the types above, and
+// the types their values are stored as.
+var goTypes = map[string]string{
+	"int8": TypeInt, "int16": TypeInt, "int32": TypeInt, "int64": TypeInt,
+	"uint": TypeInt, "uint8": TypeInt, "uint16": TypeInt, "uint32": TypeInt, "uint64": TypeInt,
+	"float32": TypeFloat, "float64": TypeFloat,
+	"time.Time": TypeString, "[]uint8": TypeString, "[]byte": TypeString,
+}
+
+// anyType is the name of the Go type accepting any value.
+const anyType = "interface {}"
+
+// goType translates the name of a Go type, as given by reflect, to the
+// type it's values are stored as. Pointers are the type they point to,
+// maps and structs are objects, and slices are lists of their elements'
+// type, told by list. ok is false for the builtin types and doctypes,
+// the builtin "int", "string" and "bool" being Go names too.
+func goType(name string) (expectedType string, list bool, ok bool) {
+	trimmed := strings.TrimLeft(name, "*")
+	if builtinTypes[trimmed] {
+		return trimmed, false, trimmed != name
+	}
+	name = trimmed
+
+	if expectedType, ok := goTypes[name]; ok {
+		return expectedType, false, true
+	}
+
+	switch {
+	case name == anyType:
+		return anyType, false, true
+	case strings.HasPrefix(name, "[]"):
+		expectedType, _, ok := goType(name[2:])
+		if !ok {
+			expectedType = name[2:]
+		}
+		return expectedType, true, true
+	case strings.HasPrefix(name, "map["), isStructName(name):
+		return TypeObject, false, true
+	}
+
+	return name, false, false
+}
+
+// isStructName tells if the name is of a Go type of another package,
+// like datastore.Document, and not a doctype's code.
+func isStructName(name string) bool {
+	i := strings.LastIndex(name, ".")
+	return i > 0 && i < len(name)-1 && unicode.IsUpper(rune(name[i+1]))
+}
+
+// accepts tells if the value matches any of the field's expected types.
+func (f *Field) accepts(value interface{}) bool {
+	for _, expectedType := range f.ExpectedTypes {
+		if matchesType(expectedType, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesType tells if the value is of the given type.
+func matchesType(expectedType string, value interface{}) bool {
+	if name, list, ok := goType(expectedType); ok {
+		if !list {
+			return name == anyType || matchesType(name, value)
+		}
+
+		switch value.(type) {
+		case []interface{}, []string:
+		default:
+			return false
+		}
+		for _, v := range toSlice(value) {
+			if !matchesType(name, v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch expectedType {
+	case TypeString:
+		_, ok := value.(string)
+		return ok
+	case TypeInt:
+		number, ok := toFloat(value)
+		return ok && number == math.Trunc(number)
+	case TypeFloat:
+		_, ok := toFloat(value)
+		return ok
+	case TypeBool:
+		_, ok := value.(bool)
+		return ok
+	case TypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+
+	id, ok := value.(string)
+	return ok && isReference(expectedType, id)
+}
+
+// isReference tells if id is a document of the doctype, or of a
+// doctype extending it.
+func isReference(doctypeCode string, id string) bool {
+	doctypeID := Conn.HGet(id, "doctype").Val()
+	if len(doctypeID) == 0 {
+		return false
+	}
+
+	code := Conn.HGet(doctypeID, "code").Val()
+	return extends([]string{code}, doctypeCode, map[string]bool{})
+}
+
+// isObject tells if the field's values are sub-documents.
+func (f *Field) isObject() bool {
+	for _, expectedType := range f.ExpectedTypes {
+		if expectedType == TypeObject {
+			return true
+		}
+	}
+	return false
+}
+
+// inSet tells if the field's values are stored on a set. Lists of
+// sub-documents keep their order, so they're stored as a whole.
+func (f *Field) inSet() bool {
+	return f.MultipleValues && !f.isObject()
+}
+
+// onlyStrings tells if all the values of the field are strings,
+// so they can be stored as they are.
+func (f *Field) onlyStrings() bool {
+	for _, expectedType := range f.ExpectedTypes {
+		if _, _, ok := goType(expectedType); ok && expectedType != "time.Time" {
+			return false
+		}
+		if builtinTypes[expectedType] && expectedType != TypeString {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeValue to be stored on Redis.
+// Strings are stored as they are, anything else as JSON.
+func (f *Field) encodeValue(value interface{}) string {
+	if str, ok := value.(string); ok && f.onlyStrings() {
+		return str
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(encoded)
+}
+
+// decodeValue stored on Redis.
+func (f *Field) decodeValue(encoded string) interface{} {
+	if f.onlyStrings() {
+		return encoded
+	}
+
+	var value interface{}
+	if json.Unmarshal([]byte(encoded), &value) != nil {
+		// stored before the field expected other types
+		return encoded
+	}
+
+	return value
+}
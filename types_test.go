@@ -0,0 +1,140 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestTypes(t *testing.T) {
+	Convey("Create a doctype with typed fields", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "measure",
+			"verbose_name": "Measure",
+			"fields": {
+				"label": {
+					"verbose_name": "Label",
+					"expected_types": ["string"]
+				},
+				"count": {
+					"verbose_name": "Count",
+					"expected_types": ["int"]
+				},
+				"value": {
+					"verbose_name": "Value",
+					"expected_types": ["float", "string"]
+				},
+				"active": {
+					"verbose_name": "Active",
+					"expected_types": ["bool"]
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+
+		Convey("Typed values are stored and loaded back", func() {
+			So(doctypeCreated.Save(), ShouldBeNil)
+
+			createDocumentJSON := strings.NewReader(`{
+				"slug": "typed-measure",
+				"doctype": "measure",
+				"fields": {
+					"label": "Temperature",
+					"count": 3,
+					"value": 21.5,
+					"active": true,
+					"unit": "celsius"
+				}
+			}`)
+
+			documentCreated := Document{}
+			err := documentCreated.Decode(createDocumentJSON)
+			if err != nil {
+				panic(err)
+			}
+			So(documentCreated.Save(), ShouldBeNil)
+
+			documentLoaded, documentLoadedErr := LoadDocumentByID(documentCreated.ID)
+			if documentLoadedErr != nil {
+				panic(documentLoadedErr)
+			}
+
+			So(documentLoaded.Fields, ShouldResemble, documentCreated.Fields)
+		})
+
+		Convey("Type mismatches are reported by field", func() {
+			So(doctypeCreated.Save(), ShouldBeNil)
+
+			documentCreated := &Document{
+				Slug:        "mistyped-measure",
+				DoctypeCode: "measure",
+				Fields: map[string]interface{}{
+					"label":  42.0,
+					"count":  1.5,
+					"value":  "warm",
+					"active": "yes",
+				},
+			}
+
+			err := documentCreated.Save()
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(err.(*ValidationError).Fields, ShouldResemble, map[string][]string{
+				"label":  {"must be of type string"},
+				"count":  {"must be of type int"},
+				"active": {"must be of type bool"},
+			})
+		})
+
+		Convey("Strict doctypes refuse unknown fields", func() {
+			doctypeCreated.Strict = true
+			So(doctypeCreated.Save(), ShouldBeNil)
+
+			documentCreated := &Document{
+				Slug:        "strict-measure",
+				DoctypeCode: "measure",
+				Fields: map[string]interface{}{
+					"label": "Pressure",
+					"unit":  "bar",
+				},
+			}
+
+			err := documentCreated.Save()
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(err.(*ValidationError).Fields, ShouldResemble, map[string][]string{
+				"unit": {"is not a field of measure"},
+			})
+		})
+	})
+}
+
+func TestGoTypeNames(t *testing.T) {
+	Convey("Fields expecting the names of Go types", t, func() {
+		field := func(expectedType string) *Field {
+			return &Field{ExpectedTypes: []string{expectedType}}
+		}
+
+		So(field("int64").accepts(5.0), ShouldBeTrue)
+		So(field("int64").accepts(5.5), ShouldBeFalse)
+		So(field("*float64").accepts(5.5), ShouldBeTrue)
+		So(field("int").accepts(5.0), ShouldBeTrue)
+		So(field("*int").accepts(5.5), ShouldBeFalse)
+		So(field("*string").accepts("a"), ShouldBeTrue)
+		So(field("time.Time").accepts("2016-01-02T03:04:05Z"), ShouldBeTrue)
+		So(field("[]string").accepts([]interface{}{"a", "b"}), ShouldBeTrue)
+		So(field("[]string").accepts([]interface{}{"a", 1.0}), ShouldBeFalse)
+		So(field("map[string]interface {}").accepts(map[string]interface{}{}), ShouldBeTrue)
+		So(field("*datastore.Author").accepts(map[string]interface{}{"name": "Ada"}), ShouldBeTrue)
+		So(field("interface {}").accepts(true), ShouldBeTrue)
+
+		Convey("are stored as their values' types", func() {
+			So(field("int64").onlyStrings(), ShouldBeFalse)
+			So(field("int64").decodeValue("5"), ShouldEqual, 5.0)
+			So(field("time.Time").onlyStrings(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,266 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/redis.v3"
+	"sort"
+	"strings"
+)
+
+// DuplicateError is returned when a document uses values that must be
+// unique and are already used by another document.
+type DuplicateError struct {
+	// Constraints violated: "slug", a field's code, or the codes of
+	// fields unique together joined by "+".
+	Constraints []string
+}
+
+// Error implements error
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("Duplicate values for: %s", strings.Join(e.Constraints, ", "))
+}
+
+// uniqueConstraint is a value the document must be the only one using.
+type uniqueConstraint struct {
+	name string
+
+	// hash indexing the values to the documents using them
+	key   string
+	value string
+}
+
+// claimScript checks no other document uses the values and, if so,
+// indexes them to the document, releasing the values it used before.
+//
+// KEYS[1] is the hash of the values used by the document, the other
+// KEYS the indexes. ARGV[1] is the document's ID and the other ARGV
+// the values, empty to release the index.
+// Returns the positions of the values used by other documents and,
+// when there are none, the values the document used before.
+const claimScript = `
+local doc = ARGV[1]
+local conflicts = {}
+local previous = {}
+
+for i = 2, #KEYS do
+	if ARGV[i] ~= '' then
+		local owner = redis.call('HGET', KEYS[i], ARGV[i])
+		if owner and owner ~= doc then
+			table.insert(conflicts, i - 1)
+		end
+	end
+end
+
+if #conflicts > 0 then
+	return {conflicts, previous}
+end
+
+for i = 2, #KEYS do
+	local old = redis.call('HGET', KEYS[1], KEYS[i])
+	table.insert(previous, old or '')
+
+	if old and old ~= ARGV[i] then
+		if redis.call('HGET', KEYS[i], old) == doc then
+			redis.call('HDEL', KEYS[i], old)
+		end
+		redis.call('HDEL', KEYS[1], KEYS[i])
+	end
+
+	if ARGV[i] ~= '' then
+		redis.call('HSET', KEYS[i], ARGV[i], doc)
+		redis.call('HSET', KEYS[1], KEYS[i], ARGV[i])
+	end
+end
+
+return {conflicts, previous}
+`
+
+// unclaimScript undoes a claim, releasing the values claimed and
+// claiming back the ones used before, unless others took them since.
+//
+// KEYS are the ones of the claim. ARGV[1] is the document's ID, then
+// come the values claimed and then the ones used before.
+const unclaimScript = `
+local doc = ARGV[1]
+local n = #KEYS - 1
+
+for i = 2, #KEYS do
+	local claimed = ARGV[i]
+	local previous = ARGV[i + n]
+
+	if claimed ~= previous then
+		if claimed ~= '' and redis.call('HGET', KEYS[i], claimed) == doc then
+			redis.call('HDEL', KEYS[i], claimed)
+		end
+
+		local owner = false
+		if previous ~= '' then
+			owner = redis.call('HGET', KEYS[i], previous)
+		end
+
+		if previous ~= '' and (not owner or owner == doc) then
+			redis.call('HSET', KEYS[i], previous, doc)
+			redis.call('HSET', KEYS[1], KEYS[i], previous)
+		else
+			redis.call('HDEL', KEYS[1], KEYS[i])
+		end
+	end
+end
+
+return 0
+`
+
+// uniqueConstraints returns the values the document must be the
+// only one using: it's slug on the doctype, the values of unique
+// fields and of the fields unique together.
+func (d *Document) uniqueConstraints() []uniqueConstraint {
+	constraints := []uniqueConstraint{{
+		name:  "slug",
+		key:   joinKey([]string{d.Doctype.ID, "slugs"}),
+		value: d.Slug,
+	}}
+
+	codes := make([]string, 0, len(d.Doctype.Fields))
+	for code := range d.Doctype.Fields {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		field := d.Doctype.Fields[code]
+		if !field.Unique {
+			continue
+		}
+
+		constraints = append(constraints, uniqueConstraint{
+			name:  code,
+			key:   joinKey([]string{d.Doctype.ID, "unique", field.ID}),
+			value: d.uniqueValue(field),
+		})
+	}
+
+	for _, group := range d.Doctype.UniqueTogether {
+		ids := []string{}
+		values := []string{}
+
+		for _, code := range group {
+			field, ok := d.Doctype.Fields[code]
+			if !ok {
+				continue
+			}
+
+			ids = append(ids, field.ID)
+			values = append(values, d.uniqueValue(field))
+		}
+
+		value, err := json.Marshal(values)
+		if err != nil {
+			panic(err)
+		}
+
+		// documents missing any of the values don't use the group
+		for _, v := range values {
+			if len(v) == 0 {
+				value = []byte{}
+				break
+			}
+		}
+
+		constraints = append(constraints, uniqueConstraint{
+			name:  strings.Join(group, "+"),
+			key:   joinKey([]string{d.Doctype.ID, "unique", strings.Join(ids, "+")}),
+			value: string(value),
+		})
+	}
+
+	return constraints
+}
+
+// uniqueValue of the field, as indexed. Empty for missing values.
+func (d *Document) uniqueValue(f *Field) string {
+	value := d.Fields[f.Code]
+	if isEmpty(value) {
+		return ""
+	}
+
+	if f.inSet() {
+		values := []string{}
+		for _, v := range toSlice(value) {
+			values = append(values, f.encodeValue(v))
+		}
+		sort.Strings(values)
+
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			panic(err)
+		}
+		return string(encoded)
+	}
+
+	return f.encodeValue(value)
+}
+
+// claimUnique atomically claims the document's unique values.
+// Returns a *DuplicateError if any is used by other document.
+//
+// The claim is made before the document is saved, so it's returned
+// function undoes it when the save fails.
+func (d *Document) claimUnique() (func() error, error) {
+	constraints := d.uniqueConstraints()
+
+	keys := []string{joinKey([]string{d.ID, "unique"})}
+	args := []string{d.ID}
+	for _, constraint := range constraints {
+		keys = append(keys, constraint.key)
+		args = append(args, constraint.value)
+	}
+
+	res, err := Conn.Eval(claimScript, keys, args).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, _ := res.([]interface{})
+	if len(reply) != 2 {
+		return nil, fmt.Errorf("Unexpected unique claim reply: %v", res)
+	}
+	conflicts, _ := reply[0].([]interface{})
+	previous, _ := reply[1].([]interface{})
+
+	if len(conflicts) == 0 {
+		if len(previous) != len(constraints) {
+			return nil, fmt.Errorf("Unexpected unique claim reply: %v", res)
+		}
+
+		for _, value := range previous {
+			str, _ := value.(string)
+			args = append(args, str)
+		}
+
+		return func() error {
+			return Conn.Eval(unclaimScript, keys, args).Err()
+		}, nil
+	}
+
+	duplicate := &DuplicateError{}
+	for _, position := range conflicts {
+		i, ok := position.(int64)
+		if !ok || i < 1 || int(i) > len(constraints) {
+			return nil, fmt.Errorf("Unexpected unique claim reply: %v", res)
+		}
+		duplicate.Constraints = append(duplicate.Constraints, constraints[i-1].name)
+	}
+
+	return nil, duplicate
+}
+
+// releaseUnique frees the values claimed by the document.
+func (d *Document) releaseUnique(pipeline *redis.Pipeline) {
+	documentKey := joinKey([]string{d.ID, "unique"})
+
+	for key, value := range Conn.HGetAllMap(documentKey).Val() {
+		pipeline.HDel(key, value)
+	}
+	pipeline.Del(documentKey)
+}
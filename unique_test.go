@@ -0,0 +1,143 @@
+package datastore
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestUnique(t *testing.T) {
+	Convey("Create a doctype with unique constraints", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "account",
+			"verbose_name": "Account",
+			"unique_together": [["first_name", "last_name"]],
+			"fields": {
+				"email": {
+					"verbose_name": "Email",
+					"expected_types": ["string"],
+					"unique": true
+				},
+				"first_name": {
+					"verbose_name": "First name",
+					"expected_types": ["string"]
+				},
+				"last_name": {
+					"verbose_name": "Last name",
+					"expected_types": ["string"]
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+		So(doctypeCreated.Save(), ShouldBeNil)
+
+		first := &Document{
+			Slug:        "john-doe",
+			DoctypeCode: "account",
+			Fields: map[string]interface{}{
+				"email":      "john@example.com",
+				"first_name": "John",
+				"last_name":  "Doe",
+			},
+		}
+		So(first.Save(), ShouldBeNil)
+
+		Convey("Duplicated slugs, fields and groups are refused", func() {
+			second := &Document{
+				Slug:        "john-doe",
+				DoctypeCode: "account",
+				Fields: map[string]interface{}{
+					"email":      "john@example.com",
+					"first_name": "John",
+					"last_name":  "Doe",
+				},
+			}
+
+			err := second.Save()
+			So(err, ShouldHaveSameTypeAs, &DuplicateError{})
+			So(err.(*DuplicateError).Constraints, ShouldResemble, []string{
+				"slug", "email", "first_name+last_name",
+			})
+		})
+
+		Convey("Values are released when changed", func() {
+			first.Slug = "john-roe"
+			first.Fields["email"] = "roe@example.com"
+			first.Fields["last_name"] = "Roe"
+			So(first.Save(), ShouldBeNil)
+
+			second := &Document{
+				Slug:        "john-doe",
+				DoctypeCode: "account",
+				Fields: map[string]interface{}{
+					"email":      "john@example.com",
+					"first_name": "John",
+					"last_name":  "Doe",
+				},
+			}
+			So(second.Save(), ShouldBeNil)
+		})
+
+		Convey("Claims are undone when the save fails", func() {
+			doctypeLoaded, doctypeLoadedErr := LoadDoctypeByCode("account")
+			if doctypeLoadedErr != nil {
+				panic(doctypeLoadedErr)
+			}
+
+			// a new document releases what it claimed
+			second := &Document{
+				ID:      GenerateID(8),
+				Slug:    "jane-doe",
+				Doctype: doctypeLoaded,
+				Fields:  map[string]interface{}{"email": "jane@example.com"},
+			}
+			unclaim, claimErr := second.claimUnique()
+			So(claimErr, ShouldBeNil)
+			So(unclaim(), ShouldBeNil)
+
+			third := &Document{
+				Slug:        "jane-doe",
+				DoctypeCode: "account",
+				Fields:      map[string]interface{}{"email": "jane@example.com"},
+			}
+			So(third.Save(), ShouldBeNil)
+
+			// a document already saved claims back what it used
+			first.Doctype = doctypeLoaded
+			first.Fields["email"] = "johnny@example.com"
+			unclaim, claimErr = first.claimUnique()
+			So(claimErr, ShouldBeNil)
+			So(unclaim(), ShouldBeNil)
+
+			fourth := &Document{
+				Slug:        "johnny",
+				DoctypeCode: "account",
+				Fields:      map[string]interface{}{"email": "john@example.com"},
+			}
+			So(fourth.Save(), ShouldHaveSameTypeAs, &DuplicateError{})
+		})
+
+		Convey("The same slug can be used by another doctype", func() {
+			other := &Doctype{
+				Code:        "profile",
+				VerboseName: "Profile",
+				Fields: map[string]*Field{
+					"bio": {VerboseName: "Bio", ExpectedTypes: []string{"string"}},
+				},
+			}
+			So(other.Save(), ShouldBeNil)
+
+			profile := &Document{
+				Slug:        "john-doe",
+				DoctypeCode: "profile",
+				Fields:      map[string]interface{}{"bio": "Hello"},
+			}
+			So(profile.Save(), ShouldBeNil)
+		})
+	})
+}
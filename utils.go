@@ -3,6 +3,7 @@ package datastore
 import (
 	"strings"
 	"encoding/json"
+	"fmt"
 )
 
 // Joins multiple strings separated by /
@@ -34,3 +35,51 @@ func FromStructToMap(stru interface{}) map[string]interface{} {
 
 	return ma
 }
+
+// Helper to fill a Struct from a Map
+func FromMapToStruct(ma map[string]interface{}, stru interface{}) error {
+	json_string, err := json.Marshal(ma)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(json_string, stru)
+}
+
+// Helper to convert a multiple values field's value
+// to a slice of strings
+func toStringSlice(value interface{}) []string {
+	switch values := value.(type) {
+	case []string:
+		return values
+	case []interface{}:
+		strs := make([]string, 0, len(values))
+		for _, v := range values {
+			strs = append(strs, fmt.Sprint(v))
+		}
+		return strs
+	case nil:
+		return []string{}
+	}
+
+	return []string{fmt.Sprint(value)}
+}
+
+// Helper to convert a multiple values field's value
+// to a slice
+func toSlice(value interface{}) []interface{} {
+	switch values := value.(type) {
+	case []interface{}:
+		return values
+	case []string:
+		slice := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			slice = append(slice, v)
+		}
+		return slice
+	case nil:
+		return []interface{}{}
+	}
+
+	return []interface{}{value}
+}
@@ -0,0 +1,197 @@
+package datastore
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError reports the fields of a document that aren't valid.
+type ValidationError struct {
+	// Problems found, by field's code
+	Fields map[string][]string `json:"fields"`
+}
+
+// Add a problem found on the field.
+func (e *ValidationError) Add(field string, format string, args ...interface{}) {
+	if e.Fields == nil {
+		e.Fields = map[string][]string{}
+	}
+	e.Fields[field] = append(e.Fields[field], fmt.Sprintf(format, args...))
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	codes := make([]string, 0, len(e.Fields))
+	for code := range e.Fields {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	problems := make([]string, 0, len(codes))
+	for _, code := range codes {
+		problems = append(problems, fmt.Sprintf("%s %s", code, strings.Join(e.Fields[code], ", ")))
+	}
+
+	return fmt.Sprintf("Invalid document: %s", strings.Join(problems, "; "))
+}
+
+// Validate the document's values against it's doctype's field rules
+// and expected types. Strict doctypes also refuse values for fields
+// they don't define.
+// Returns a *ValidationError when any field isn't valid.
+func (d *Document) Validate() error {
+	report := &ValidationError{}
+
+	validateFields(d.Doctype.Fields, d.Fields, d.Doctype.Code, "", d.Doctype.Strict, report)
+
+	if len(report.Fields) > 0 {
+		return report
+	}
+
+	return nil
+}
+
+// validateFields validates the values against the fields' definitions,
+// reporting the problems found by the fields' codes, prefixed. owner
+// is the name of what defines the fields, for the messages.
+func validateFields(fields map[string]*Field, values map[string]interface{}, owner string, prefix string, strict bool, report *ValidationError) {
+	if strict {
+		for code := range values {
+			if _, known := fields[code]; !known {
+				report.Add(prefix+code, "is not a field of %s", owner)
+			}
+		}
+	}
+
+	for code, field := range fields {
+		value := values[code]
+		path := prefix + code
+
+		if isEmpty(value) {
+			if field.Required && !field.Deprecated {
+				report.Add(path, "is required")
+			}
+			continue
+		}
+
+		if field.MultipleValues {
+			switch value.(type) {
+			case []interface{}, []string:
+			default:
+				report.Add(path, "must be a list")
+				continue
+			}
+
+			values := toSlice(value)
+
+			if field.MinItems != nil && len(values) < *field.MinItems {
+				report.Add(path, "must have at least %d items", *field.MinItems)
+			}
+			if field.MaxItems != nil && len(values) > *field.MaxItems {
+				report.Add(path, "must have at most %d items", *field.MaxItems)
+			}
+
+			for i, v := range values {
+				// sub-documents are reported by their position
+				if field.isObject() {
+					field.validate(fmt.Sprintf("%s.%d", path, i), v, strict, report)
+				} else {
+					field.validate(path, v, strict, report)
+				}
+			}
+		} else {
+			field.validate(path, value, strict, report)
+		}
+	}
+}
+
+// validate a single value against the field's type and rules.
+func (f *Field) validate(code string, value interface{}, strict bool, report *ValidationError) {
+	if !f.accepts(value) {
+		report.Add(code, "must be of type %s", strings.Join(f.ExpectedTypes, " or "))
+		return
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		validateFields(f.Fields, object, code, code+".", strict && len(f.Fields) > 0, report)
+		return
+	}
+	if number, ok := toFloat(value); ok {
+		if f.Min != nil && number < *f.Min {
+			report.Add(code, "must be at least %v", *f.Min)
+		}
+		if f.Max != nil && number > *f.Max {
+			report.Add(code, "must be at most %v", *f.Max)
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		length := utf8.RuneCountInString(str)
+
+		if f.MinLength != nil && length < *f.MinLength {
+			report.Add(code, "must have at least %d characters", *f.MinLength)
+		}
+		if f.MaxLength != nil && length > *f.MaxLength {
+			report.Add(code, "must have at most %d characters", *f.MaxLength)
+		}
+
+		if len(f.Pattern) > 0 {
+			matched, err := regexp.MatchString(f.Pattern, str)
+			if err != nil {
+				report.Add(code, "has an invalid pattern: %s", err)
+			} else if !matched {
+				report.Add(code, "must match %s", f.Pattern)
+			}
+		}
+	}
+
+	if len(f.Enum) > 0 {
+		found := false
+		for _, option := range f.Enum {
+			if fmt.Sprint(option) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report.Add(code, "must be one of %v", f.Enum)
+		}
+	}
+}
+
+// isEmpty tells if the value should be considered as missing.
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// toFloat converts numeric values to float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
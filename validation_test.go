@@ -0,0 +1,110 @@
+package datastore
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidation(t *testing.T) {
+	Convey("Create a doctype with validation rules", t, func() {
+		createDoctypeJSON := strings.NewReader(`{
+			"code": "product",
+			"verbose_name": "Product",
+			"fields": {
+				"name": {
+					"verbose_name": "Name",
+					"expected_types": ["string"],
+					"required": true,
+					"max_length": 10
+				},
+				"sku": {
+					"verbose_name": "SKU",
+					"expected_types": ["string"],
+					"pattern": "^[A-Z]{3}-[0-9]+$",
+					"unique": true
+				},
+				"color": {
+					"verbose_name": "Color",
+					"expected_types": ["string"],
+					"enum": ["red", "blue"]
+				},
+				"tags": {
+					"verbose_name": "Tags",
+					"expected_types": ["string"],
+					"multiple_values": true,
+					"max_items": 2
+				}
+			}
+		}`)
+
+		doctypeCreated := Doctype{}
+		err := doctypeCreated.Decode(createDoctypeJSON)
+		if err != nil {
+			panic(err)
+		}
+
+		saveErr := doctypeCreated.Save()
+		if saveErr != nil {
+			panic(saveErr)
+		}
+
+		Convey("Rules are loaded with the doctype", func() {
+			doctypeLoaded, docErr := LoadDoctypeByID(doctypeCreated.ID)
+			if docErr != nil {
+				panic(docErr)
+			}
+
+			So(doctypeLoaded.Fields["name"].Required, ShouldBeTrue)
+			So(*doctypeLoaded.Fields["name"].MaxLength, ShouldEqual, 10)
+			So(doctypeLoaded.Fields["color"].Enum, ShouldResemble, []interface{}{"red", "blue"})
+		})
+
+		Convey("Invalid documents are reported by field", func() {
+			documentCreated := &Document{
+				Slug:        "invalid-product",
+				DoctypeCode: "product",
+				Fields: map[string]interface{}{
+					"name":  "",
+					"sku":   "abc",
+					"color": "green",
+					"tags":  []interface{}{"a", "b", "c"},
+				},
+			}
+
+			err := documentCreated.Save()
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(err.(*ValidationError).Fields, ShouldResemble, map[string][]string{
+				"name":  {"is required"},
+				"sku":   {"must match ^[A-Z]{3}-[0-9]+$"},
+				"color": {"must be one of [red blue]"},
+				"tags":  {"must have at most 2 items"},
+			})
+		})
+
+		Convey("Unique values can't be used twice", func() {
+			sku := fmt.Sprintf("ABC-%d", time.Now().UnixNano())
+
+			first := &Document{
+				Slug:        "first-product",
+				DoctypeCode: "product",
+				Fields:      map[string]interface{}{"name": "First", "sku": sku, "color": "red", "tags": []string{}},
+			}
+			So(first.Save(), ShouldBeNil)
+
+			second := &Document{
+				Slug:        "second-product",
+				DoctypeCode: "product",
+				Fields:      map[string]interface{}{"name": "Second", "sku": sku, "color": "red", "tags": []string{}},
+			}
+			err := second.Save()
+			So(err, ShouldHaveSameTypeAs, &DuplicateError{})
+			So(err.(*DuplicateError).Constraints, ShouldResemble, []string{"sku"})
+
+			So(first.Delete(), ShouldBeNil)
+			So(second.Save(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,416 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/redis.v3"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WebhookDeadLetters is the list of deliveries that failed
+// after all the attempts, or whose payload couldn't be built.
+const WebhookDeadLetters = "webhooks/dead"
+
+// WebhookRetries is the sorted set of deliveries waiting to be
+// retried, scored by when they're due.
+const WebhookRetries = "webhooks/retries"
+
+// SignatureHeader is the HTTP header holding the payload's signature.
+const SignatureHeader = "X-Datastore-Signature"
+
+// Webhook is an URL notified of changes to a doctype's documents.
+type Webhook struct {
+	ID string `json:"id"`
+
+	// Doctype whose documents are watched
+	DoctypeCode string `json:"doctype"`
+
+	// URL the payload is POSTed to
+	URL string `json:"url"`
+
+	// Secret used to sign the payloads, no signature is sent without it
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookPayload is the JSON body sent to the webhooks.
+type WebhookPayload struct {
+	ChangeType string               `json:"change_type"`
+	Document   *Document            `json:"document"`
+	Revision   *Revision            `json:"revision"`
+	Diff       map[string]FieldDiff `json:"diff"`
+}
+
+// WebhookDeadLetter is a delivery that failed.
+type WebhookDeadLetter struct {
+	Webhook *Webhook `json:"webhook"`
+
+	// Change that was delivered
+	Event *Event `json:"event,omitempty"`
+
+	// The WebhookPayload sent, null when it couldn't be built
+	Payload json.RawMessage `json:"payload"`
+
+	Error string    `json:"error"`
+	When  time.Time `json:"when"`
+}
+
+// webhookDelivery is a delivery waiting to be retried.
+type webhookDelivery struct {
+	// Makes the same payload sent twice to the webhook two retries
+	ID string `json:"id"`
+
+	Webhook  *Webhook        `json:"webhook"`
+	Event    *Event          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+}
+
+// Save the webhook to the database.
+func (w *Webhook) Save() error {
+	// Generates an ID if there's no one set
+	if len(w.ID) == 0 {
+		w.ID = GenerateID(4)
+	}
+
+	return intercept(&Operation{Kind: OpSave, ObjectType: "webhook", ID: w.ID, Object: w}, w.save)
+}
+
+func (w *Webhook) save() error {
+	pipeline := Conn.Pipeline()
+
+	pipeline.HSet(w.ID, "type", "webhook")
+	pipeline.HSet(w.ID, "doctype", w.DoctypeCode)
+	pipeline.HSet(w.ID, "url", w.URL)
+	pipeline.HSet(w.ID, "secret", w.Secret)
+
+	pipeline.SAdd(joinKey([]string{"webhooks", w.DoctypeCode}), w.ID)
+
+	_, err := pipeline.Exec()
+	pipeline.Close()
+
+	return err
+}
+
+// Delete the webhook from the database.
+func (w *Webhook) Delete() error {
+	return intercept(&Operation{Kind: OpDelete, ObjectType: "webhook", ID: w.ID, Object: w}, w.delete)
+}
+
+func (w *Webhook) delete() error {
+	pipeline := Conn.Pipeline()
+
+	pipeline.SRem(joinKey([]string{"webhooks", w.DoctypeCode}), w.ID)
+	pipeline.Del(w.ID)
+
+	_, err := pipeline.Exec()
+	pipeline.Close()
+
+	return err
+}
+
+// LoadWebhooks loads the webhooks registered for the doctype.
+func LoadWebhooks(doctypeCode string) ([]*Webhook, error) {
+	webhooks := []*Webhook{}
+
+	ids, err := Conn.SMembers(joinKey([]string{"webhooks", doctypeCode})).Result()
+	if err != nil {
+		return webhooks, err
+	}
+
+	for _, id := range ids {
+		get := Conn.HGetAllMap(id).Val()
+
+		if get["type"] != "webhook" {
+			return webhooks, fmt.Errorf("%s is type '%s', expecting 'webhook'", id, get["type"])
+		}
+
+		webhooks = append(webhooks, &Webhook{
+			ID:          id,
+			DoctypeCode: get["doctype"],
+			URL:         get["url"],
+			Secret:      get["secret"],
+		})
+	}
+
+	return webhooks, nil
+}
+
+// Sign the payload with the webhook's secret, using HMAC-SHA256.
+func (w *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers the changes on the stream to the webhooks.
+//
+// It reads the stream as a consumer of the "webhooks" group, so changes
+// are delivered even if they happened while the dispatcher was down.
+// Failed deliveries are retried later, without holding the changes
+// after them.
+type WebhookDispatcher struct {
+	Consumer *Consumer
+	Client   *http.Client
+
+	// Attempts made before giving up a delivery
+	MaxAttempts int
+
+	// Wait before the first retry, doubled on each retry
+	Backoff time.Duration
+}
+
+// NewWebhookDispatcher creates a dispatcher with default settings.
+func NewWebhookDispatcher(name string) (*WebhookDispatcher, error) {
+	consumer, err := NewConsumer("webhooks", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookDispatcher{
+		Consumer:    consumer,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}, nil
+}
+
+// Run dispatches the changes until stop is closed, waiting
+// interval when there's nothing to do.
+func (w *WebhookDispatcher) Run(stop <-chan struct{}, interval time.Duration) error {
+	for {
+		n, err := w.Dispatch(100)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			continue
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Dispatch up to count changes of the stream, after up to count of the
+// retries due. Returns how many changes and retries were handled.
+//
+// Changes whose payload can't be built are sent to the dead letters,
+// like the deliveries that failed, so they don't block the ones after
+// them. Only failing to read or write to the database stops it.
+func (w *WebhookDispatcher) Dispatch(count int) (int, error) {
+	retried, err := w.retry(count)
+	if err != nil {
+		return retried, err
+	}
+
+	entries, err := w.Consumer.Read(count)
+	if err != nil {
+		return retried, err
+	}
+
+	for _, entry := range entries {
+		err = w.dispatch(entry.Event)
+		if err != nil {
+			return retried, err
+		}
+
+		err = w.Consumer.Ack(entry.ID)
+		if err != nil {
+			return retried, err
+		}
+	}
+
+	return retried + len(entries), nil
+}
+
+// dispatch the event to the webhooks of the document's doctype.
+func (w *WebhookDispatcher) dispatch(e Event) error {
+	if e.ObjectType != "document" {
+		return nil
+	}
+
+	webhooks, err := LoadWebhooks(e.DoctypeCode)
+	if err != nil {
+		return w.deadLetter(WebhookDeadLetter{Event: &e, Error: err.Error()})
+	}
+
+	var body []byte
+	payload, buildErr := buildWebhookPayload(e)
+	if buildErr == nil {
+		body, buildErr = json.Marshal(payload)
+	}
+	if buildErr != nil {
+		for _, webhook := range webhooks {
+			err = w.deadLetter(WebhookDeadLetter{Webhook: webhook, Event: &e, Error: buildErr.Error()})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, webhook := range webhooks {
+		err = w.attempt(&webhookDelivery{
+			ID:      GenerateID(8),
+			Webhook: webhook,
+			Event:   &e,
+			Payload: body,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attempt the delivery. When it fails it's scheduled to be retried
+// after the backoff, doubled on each attempt, or sent to the dead
+// letters after the last attempt.
+func (w *WebhookDispatcher) attempt(delivery *webhookDelivery) error {
+	deliveryErr := w.post(delivery.Webhook, delivery.Payload)
+	if deliveryErr == nil {
+		return nil
+	}
+
+	delivery.Attempts++
+	if delivery.Attempts >= w.MaxAttempts {
+		return w.deadLetter(WebhookDeadLetter{
+			Webhook: delivery.Webhook,
+			Event:   delivery.Event,
+			Payload: delivery.Payload,
+			Error:   deliveryErr.Error(),
+		})
+	}
+
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().Add(w.Backoff << uint(delivery.Attempts-1))
+
+	return Conn.ZAdd(WebhookRetries, redis.Z{
+		Score:  float64(due.UnixNano() / int64(time.Microsecond)),
+		Member: string(encoded),
+	}).Err()
+}
+
+// retry up to count of the deliveries due. Each one is removed from the
+// retries before it's attempted, so only one dispatcher attempts it.
+// Returns how many were retried.
+func (w *WebhookDispatcher) retry(count int) (int, error) {
+	now := time.Now().UnixNano() / int64(time.Microsecond)
+
+	due, err := Conn.ZRangeByScore(WebhookRetries, redis.ZRangeByScore{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+	for _, member := range due {
+		removed, err := Conn.ZRem(WebhookRetries, member).Result()
+		if err != nil {
+			return retried, err
+		}
+		if removed == 0 {
+			// taken by another dispatcher
+			continue
+		}
+
+		delivery := &webhookDelivery{}
+		err = json.Unmarshal([]byte(member), delivery)
+		if err == nil {
+			err = w.attempt(delivery)
+		} else {
+			err = w.deadLetter(WebhookDeadLetter{Payload: json.RawMessage(member), Error: err.Error()})
+		}
+		if err != nil {
+			return retried, err
+		}
+
+		retried++
+	}
+
+	return retried, nil
+}
+
+// deadLetter adds the failed delivery to the WebhookDeadLetters.
+func (w *WebhookDispatcher) deadLetter(deadLetter WebhookDeadLetter) error {
+	deadLetter.When = time.Now().UTC()
+
+	encoded, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	return Conn.RPush(WebhookDeadLetters, string(encoded)).Err()
+}
+
+func (w *WebhookDispatcher) post(webhook *Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(webhook.Secret) > 0 {
+		req.Header.Set(SignatureHeader, webhook.Sign(body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook %s answered %s", webhook.ID, resp.Status)
+	}
+
+	return nil
+}
+
+// buildWebhookPayload loads the document as it was on the event's
+// revision, or before it for deletions.
+func buildWebhookPayload(e Event) (*WebhookPayload, error) {
+	diff, err := DiffRevision(e.ObjectID, e.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := LoadDocumentRevision(e.ObjectID, e.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &WebhookPayload{
+		ChangeType: e.ChangeType,
+		Document:   d,
+		Revision:   d.Revision,
+		Diff:       diff,
+	}
+
+	if e.ChangeType == "delete" {
+		payload.Document, err = LoadDocumentRevision(e.ObjectID, d.Revision.Parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,161 @@
+package datastore
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	Convey("Register a webhook for a doctype", t, func() {
+		type request struct {
+			body      []byte
+			signature string
+		}
+		requests := make(chan request, 10)
+
+		// set by the test and read by the server's goroutines
+		var status int32 = http.StatusOK
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests <- request{body, r.Header.Get(SignatureHeader)}
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+		defer server.Close()
+
+		doctypeCreated := &Doctype{
+			Code:        "hooked",
+			VerboseName: "Hooked",
+			Fields: map[string]*Field{
+				"text": {VerboseName: "Text", ExpectedTypes: []string{"string"}},
+			},
+		}
+		doctypeCreated.Save()
+
+		webhook := &Webhook{
+			DoctypeCode: "hooked",
+			URL:         server.URL,
+			Secret:      "s3cr3t",
+		}
+		saveErr := webhook.Save()
+		if saveErr != nil {
+			panic(saveErr)
+		}
+		defer webhook.Delete()
+
+		dispatcher, dispatcherErr := NewWebhookDispatcher("test")
+		if dispatcherErr != nil {
+			panic(dispatcherErr)
+		}
+		dispatcher.Backoff = time.Millisecond
+		dispatcher.MaxAttempts = 3
+
+		// skip whatever was on the stream or waiting a retry before
+		Conn.Del(WebhookRetries)
+		for {
+			n, dispatchErr := dispatcher.Dispatch(100)
+			if dispatchErr != nil {
+				panic(dispatchErr)
+			}
+			if n == 0 {
+				break
+			}
+		}
+		for len(requests) > 0 {
+			<-requests
+		}
+
+		documentCreated := &Document{
+			Slug:        "hooked-document",
+			DoctypeCode: "hooked",
+			Fields:      map[string]interface{}{"text": "Hello"},
+		}
+
+		Convey("Deliver the change signed", func() {
+			documentCreated.Save()
+
+			_, dispatchErr := dispatcher.Dispatch(100)
+			if dispatchErr != nil {
+				panic(dispatchErr)
+			}
+
+			So(requests, ShouldHaveLength, 1)
+			r := <-requests
+
+			payload := WebhookPayload{}
+			jsonErr := json.Unmarshal(r.body, &payload)
+			if jsonErr != nil {
+				panic(jsonErr)
+			}
+
+			So(r.signature, ShouldEqual, webhook.Sign(r.body))
+			So(payload.ChangeType, ShouldEqual, "create")
+			So(payload.Document.ID, ShouldEqual, documentCreated.ID)
+			So(payload.Revision.ID, ShouldEqual, documentCreated.Revision.ID)
+			So(payload.Diff["text"].New, ShouldEqual, "Hello")
+		})
+
+		Convey("Failed deliveries are retried, then go to the dead letters", func() {
+			atomic.StoreInt32(&status, http.StatusInternalServerError)
+			before := Conn.LLen(WebhookDeadLetters).Val()
+
+			documentCreated.Save()
+
+			// the first attempt is made right away, the retries later
+			_, dispatchErr := dispatcher.Dispatch(100)
+			if dispatchErr != nil {
+				panic(dispatchErr)
+			}
+			So(requests, ShouldHaveLength, 1)
+			So(Conn.ZCard(WebhookRetries).Val(), ShouldEqual, 1)
+
+			for i := 0; i < 100 && Conn.LLen(WebhookDeadLetters).Val() == before; i++ {
+				time.Sleep(5 * time.Millisecond)
+
+				_, dispatchErr = dispatcher.Dispatch(100)
+				if dispatchErr != nil {
+					panic(dispatchErr)
+				}
+			}
+
+			So(requests, ShouldHaveLength, 3)
+			So(Conn.ZCard(WebhookRetries).Val(), ShouldEqual, 0)
+			So(Conn.LLen(WebhookDeadLetters).Val(), ShouldEqual, before+1)
+		})
+
+		Convey("Changes whose payload can't be built go to the dead letters", func() {
+			before := Conn.LLen(WebhookDeadLetters).Val()
+
+			// a change to a revision that isn't there anymore
+			pipeline := Conn.Pipeline()
+			appendChange(Event{
+				ObjectID:    GenerateID(8),
+				ObjectType:  "document",
+				DoctypeCode: "hooked",
+				ChangeType:  "update",
+				Revision:    GenerateID(9),
+			}, pipeline)
+			_, execErr := pipeline.Exec()
+			pipeline.Close()
+			if execErr != nil {
+				panic(execErr)
+			}
+
+			n, dispatchErr := dispatcher.Dispatch(100)
+			So(dispatchErr, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(Conn.LLen(WebhookDeadLetters).Val(), ShouldEqual, before+1)
+
+			// and it's not delivered again
+			n, dispatchErr = dispatcher.Dispatch(100)
+			So(dispatchErr, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+	})
+}